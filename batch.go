@@ -0,0 +1,104 @@
+package prencrypt
+
+import (
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+// ReEncapsulateBatch re-encrypts many capsules with one kfrag. Capsules are verified
+// in one batch, re-encrypted by at most GOMAXPROCS workers, and the resulting cfrags
+// are verified in one batch as well. The workers share only the window digits of
+// kfrag.Rk; every capsule point still gets its own lookup table, so the saving over
+// ReEncapsulate is small and the speedup comes from the batch checks and the workers.
+// The i-th cfrag or error belongs to the i-th capsule.
+func ReEncapsulateBatch(kfrag *kfrag.KFrag, capsules []*capsule.Capsule, aux []byte) ([]*cfrag.CFrag, []error) {
+	return ReEncapsulateBatchWithRand(nil, kfrag, capsules, aux)
+}
+
+// ReEncapsulateBatchWithRand is ReEncapsulateBatch reading randomness from random,
+// nil means crypto/rand. The batch weights and nonces for the capsules are drawn in
+// capsule order before the workers start and the weights for the cfrags after they
+// finish, so the output does not depend on scheduling.
+func ReEncapsulateBatchWithRand(random io.Reader, kfrag *kfrag.KFrag, capsules []*capsule.Capsule, aux []byte) ([]*cfrag.CFrag, []error) {
+	cfrags := make([]*cfrag.CFrag, len(capsules))
+	errs := make([]error, len(capsules))
+	if err := kfrag.Validate(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return cfrags, errs
+	}
+
+	valid := capsule.BatchVerifyWithRand(random, capsules)
+	nonces := make([]*curvebn.Scalar, len(capsules))
+	for i := range capsules {
+		if !valid[i] {
+			continue
+		}
+		t, err := curvebn.RandomScalarWithRand(random)
+		if err != nil {
+			for j := range errs {
+				errs[j] = err
+			}
+			return cfrags, errs
+		}
+		nonces[i] = t
+	}
+	rk := point.NewScalarMul(kfrag.Rk.Bytes())
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(capsules) {
+		workers = len(capsules)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if !valid[i] {
+					errs[i] = verifyCapsule(capsules[i])
+					continue
+				}
+				cfrags[i], errs[i] = reEncapsulate(kfrag, rk, capsules[i], aux, nonces[i])
+			}
+		}()
+	}
+	for i := range capsules {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var index []int
+	var caps []*capsule.Capsule
+	var frags []*cfrag.CFrag
+	for i, cfrg := range cfrags {
+		if errs[i] == nil {
+			index = append(index, i)
+			caps = append(caps, capsules[i])
+			frags = append(frags, cfrg)
+		}
+	}
+	results, err := cfrag.BatchVerifyWithRand(random, caps, frags)
+	if err != nil {
+		for _, i := range index {
+			cfrags[i], errs[i] = nil, err
+		}
+		return cfrags, errs
+	}
+	for j, ok := range results {
+		if !ok {
+			cfrags[index[j]], errs[index[j]] = nil, &ErrInvalidCFrag{Id: kfrag.Id, Reason: "proof verification failed"}
+		}
+	}
+	return cfrags, errs
+}
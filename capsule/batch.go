@@ -0,0 +1,64 @@
+package capsule
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+// BatchVerify checks s*G == h*E + V for all capsules with one multi-scalar
+// multiplication over a random linear combination, falling back to Verify on
+// each capsule when the combined check fails.
+func BatchVerify(capsules []*Capsule) []bool {
+	return BatchVerifyWithRand(nil, capsules)
+}
+
+// BatchVerifyWithRand is BatchVerify drawing the weights from random, nil means
+// crypto/rand.
+func BatchVerifyWithRand(random io.Reader, capsules []*Capsule) []bool {
+	results := make([]bool, len(capsules))
+	if len(capsules) == 0 {
+		return results
+	}
+	if batchCheck(random, capsules) {
+		for i := range results {
+			results[i] = true
+		}
+		return results
+	}
+	for i, c := range capsules {
+		results[i] = c.Verify()
+	}
+	return results
+}
+
+func batchCheck(random io.Reader, capsules []*Capsule) bool {
+	points := make([]*point.Point, 0, 2*len(capsules)+1)
+	scalars := make([]*big.Int, 0, 2*len(capsules)+1)
+	sSum := curvebn.NewScalar(big.NewInt(0))
+
+	for _, c := range capsules {
+		if c.Validate() != nil {
+			return false
+		}
+		h := Hash(c.E, c.V)
+		w, err := curvebn.RandomScalarWithRand(random)
+		if err != nil {
+			return false
+		}
+		// w*(s*G - h*E - V)
+		points = append(points, c.E, c.V)
+		scalars = append(scalars, w.Mul(h).Neg().Int(), w.Neg().Int())
+		sSum = sSum.Add(w.Mul(c.S))
+	}
+	points = append(points, point.BaseMul(big.NewInt(1)))
+	scalars = append(scalars, sSum.Int())
+
+	sum, err := point.MultiMul(points, scalars)
+	if err != nil {
+		return false
+	}
+	return sum.IsIdentity()
+}
@@ -3,35 +3,55 @@ package capsule
 import (
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/hongyuefan/prencrypt/curvebn"
-	"github.com/hongyuefan/prencrypt/keys"
 	"github.com/hongyuefan/prencrypt/point"
 	"github.com/hongyuefan/prencrypt/util"
 )
 
+// ErrInvalidCapsule is returned for a capsule that is incomplete or fails verification.
+var ErrInvalidCapsule = errors.New("invalid capsule")
+
+var checkDomain = []byte("prencrypt capsule check")
+
 type Capsule struct {
 	E *point.Point
 	V *point.Point
-	S *big.Int
+	S *curvebn.Scalar
 }
 
 func NewCapsule() *Capsule {
 	return &Capsule{
 		E: point.NewPoint(),
 		V: point.NewPoint(),
-		S: big.NewInt(0),
+		S: curvebn.NewScalar(big.NewInt(0)),
 	}
 }
 
+// Validate checks that all fields of c are set and E, V are valid points.
+func (c *Capsule) Validate() error {
+	if c == nil || c.S == nil {
+		return fmt.Errorf("%w: capsule is incomplete", ErrInvalidCapsule)
+	}
+	if err := point.ValidatePoints(c.E, c.V); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCapsule, err)
+	}
+	return nil
+}
+
 func (c *Capsule) Verify() bool {
-	h, err := curvebn.PointsHash2CurvBN(c.E, c.V)
-	if err != nil {
+	if c.Validate() != nil {
 		return false
 	}
-	point := c.E.Mul(h.Int()).Add(c.V)
-	return keys.NewPrivateKeyFromBytes(c.S.Bytes()).PublicKey.Point.IsEqual(point)
+	h := Hash(c.E, c.V)
+	return point.BaseMul(c.S.Int()).IsEqual(c.E.Mul(h.Int()).Add(c.V))
+}
+
+// Hash returns h = H(E, V), the scalar binding S = u + r*h to the capsule points.
+func Hash(E, V *point.Point) *curvebn.Scalar {
+	return curvebn.HashPointsToScalar(checkDomain, E, V)
 }
 
 func (c *Capsule) Marshal() []byte {
@@ -44,8 +64,8 @@ func (c *Capsule) Marshal() []byte {
 
 func (c *Capsule) Unmarshal(data []byte) error {
 	pointLen := point.NewPoint().Len()
-	if len(data) < pointLen*2 {
-		return errors.New("data length error")
+	if len(data) != pointLen*2+curvebn.ScalarLen {
+		return fmt.Errorf("%w: capsule data length error", util.ErrMalformedEncoding)
 	}
 	if err := c.E.Unmarshal(data[:pointLen]); err != nil {
 		return err
@@ -53,10 +73,14 @@ func (c *Capsule) Unmarshal(data []byte) error {
 	if err := c.V.Unmarshal(data[pointLen : pointLen*2]); err != nil {
 		return err
 	}
-	c.S = new(big.Int).SetBytes(data[pointLen*2:])
+	s, err := curvebn.ScalarFromBytes(data[pointLen*2:])
+	if err != nil {
+		return err
+	}
+	c.S = s
 
 	if !c.Verify() {
-		return errors.New("capsule verification failed")
+		return fmt.Errorf("%w: capsule verification failed", ErrInvalidCapsule)
 	}
 	return nil
 }
@@ -1,10 +1,25 @@
 package capsule
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCapsult(t *testing.T) {
 	cap := NewCapsule()
 	t.Log(cap.Hex())
 }
+
+func TestCapsuleIdentity(t *testing.T) {
+	// the identity has the length of any other point, so the capsule decodes to
+	// the end and is rejected as invalid rather than malformed
+	byt := append(point.Identity().Marshal(), point.UPoint().Marshal()...)
+	byt = append(byt, make([]byte, 32)...)
+	err := NewCapsule().Unmarshal(byt)
+	assert.True(t, errors.Is(err, ErrInvalidCapsule), "%v", err)
+	assert.False(t, errors.Is(err, util.ErrMalformedEncoding))
+}
@@ -0,0 +1,33 @@
+package capsule
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+func FuzzCapsuleUnmarshal(f *testing.F) {
+	r, _ := curvebn.RandomScalar()
+	u, _ := curvebn.RandomScalar()
+	E, V := point.BaseMul(r.Int()), point.BaseMul(u.Int())
+	h := Hash(E, V)
+	valid := &Capsule{E: E, V: V, S: u.Add(r.Mul(h))}
+	f.Add(valid.Marshal())
+	f.Add([]byte{})
+	f.Add(make([]byte, 2*33+curvebn.ScalarLen))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := NewCapsule()
+		if err := c.Unmarshal(data); err != nil {
+			return
+		}
+		if !c.Verify() {
+			t.Fatal("decoded capsule does not verify")
+		}
+		if !bytes.Equal(c.Marshal(), data) {
+			t.Fatal("capsule encoding is not canonical")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package cfrag
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+// BatchVerify checks the proofs of cfrags[i] against capsules[i] all at once.
+// Each proof states three equations of the form rol*X == X2 + h*X1; they are
+// combined with random weights into one multi-scalar multiplication that is the
+// identity when every equation holds. If the combined check fails, every cfrag is
+// verified on its own so the result tells which ones are invalid.
+func BatchVerify(capsules []*capsule.Capsule, cfrags []*CFrag) ([]bool, error) {
+	return BatchVerifyWithRand(nil, capsules, cfrags)
+}
+
+// BatchVerifyWithRand is BatchVerify drawing the weights from random, nil means
+// crypto/rand.
+func BatchVerifyWithRand(random io.Reader, capsules []*capsule.Capsule, cfrags []*CFrag) ([]bool, error) {
+	if len(capsules) != len(cfrags) {
+		return nil, &ErrInvalidCFrag{Reason: "capsules and cfrags length mismatch"}
+	}
+	results := make([]bool, len(cfrags))
+	if len(cfrags) == 0 {
+		return results, nil
+	}
+
+	if batchCheck(random, capsules, cfrags) {
+		for i := range results {
+			results[i] = true
+		}
+		return results, nil
+	}
+	for i, c := range cfrags {
+		results[i] = capsules[i].Validate() == nil && c.Verify(capsules[i].E, capsules[i].V)
+	}
+	return results, nil
+}
+
+func batchCheck(random io.Reader, capsules []*capsule.Capsule, cfrags []*CFrag) bool {
+	points := make([]*point.Point, 0, 8*len(cfrags)+1)
+	scalars := make([]*big.Int, 0, 8*len(cfrags)+1)
+	uSum := curvebn.NewScalar(big.NewInt(0))
+
+	for i, c := range cfrags {
+		capsl := capsules[i]
+		if c.Validate() != nil || capsl.Validate() != nil {
+			return false
+		}
+		h := c.challenge(capsl.E, capsl.V)
+
+		var w [3]*curvebn.Scalar
+		for j := range w {
+			var err error
+			if w[j], err = curvebn.RandomScalarWithRand(random); err != nil {
+				return false
+			}
+		}
+
+		// w0*(rol*E - E2 - h*E1) + w1*(rol*V - V2 - h*V1) + w2*(rol*U - U2 - h*U1)
+		points = append(points, capsl.E, c.Pi.E2, c.E1, capsl.V, c.Pi.V2, c.V1, c.Pi.U2, c.Pi.U1)
+		scalars = append(scalars,
+			w[0].Mul(c.Pi.Rol).Int(), w[0].Neg().Int(), w[0].Mul(h).Neg().Int(),
+			w[1].Mul(c.Pi.Rol).Int(), w[1].Neg().Int(), w[1].Mul(h).Neg().Int(),
+			w[2].Neg().Int(), w[2].Mul(h).Neg().Int())
+		uSum = uSum.Add(w[2].Mul(c.Pi.Rol))
+	}
+	points = append(points, point.UPoint())
+	scalars = append(scalars, uSum.Int())
+
+	sum, err := point.MultiMul(points, scalars)
+	if err != nil {
+		return false
+	}
+	return sum.IsIdentity()
+}
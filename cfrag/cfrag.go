@@ -1,77 +1,231 @@
 package cfrag
 
 import (
-	"errors"
+	"encoding/binary"
+	"fmt"
 	"math/big"
 
 	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/kfrag"
 	"github.com/hongyuefan/prencrypt/point"
 	"github.com/hongyuefan/prencrypt/util"
 )
 
+var proofDomain = []byte("prencrypt cfrag proof")
+
+// ErrInvalidCFrag is returned for a cfrag that is incomplete or whose proof does
+// not verify. Id identifies the cfrag when it is known.
+type ErrInvalidCFrag struct {
+	Id     *curvebn.Scalar
+	Reason string
+}
+
+func (e *ErrInvalidCFrag) Error() string {
+	msg := "invalid cfrag"
+	if e.Id != nil {
+		msg += " " + e.Id.Hex()
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, &ErrInvalidCFrag{}) match an ErrInvalidCFrag with any Id.
+func (e *ErrInvalidCFrag) Is(target error) bool {
+	_, ok := target.(*ErrInvalidCFrag)
+	return ok
+}
+
+// CFrag is a re-encrypted capsule fragment. Commitment, U, Path and Coefficients
+// are copied from the kfrag; Bob checks them against the policy commitment he got
+// from Alice, so that his cfrags belong to one kfrag set, lie on its gates'
+// polynomials and are enough.
 type CFrag struct {
-	Id *curvebn.CurveBN
+	Id *curvebn.Scalar
 	E1 *point.Point
 	V1 *point.Point
 	XA *point.Point
 
+	Commitment   []byte
+	U            *point.Point
+	Path         []kfrag.PathStep
+	Coefficients []*point.Point
+
 	Pi *Proof
 }
 
 func NewCFrag() *CFrag {
 	return &CFrag{
-		Id: curvebn.NewCurveBN(nil),
+		Id: curvebn.NewScalar(big.NewInt(0)),
 		E1: point.NewPoint(),
 		V1: point.NewPoint(),
 		XA: point.NewPoint(),
 	}
 }
 
+// Validate checks that all fields of c, its proof included, are set and its
+// points are valid.
+func (c *CFrag) Validate() error {
+	if c == nil || c.Id == nil {
+		return &ErrInvalidCFrag{Reason: "cfrag is incomplete"}
+	}
+	if err := point.ValidatePoints(c.E1, c.V1, c.XA); err != nil {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: err.Error()}
+	}
+	if len(c.Commitment) != kfrag.CommitmentLen {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: "cfrag commitment length error"}
+	}
+	if err := c.U.Validate(); err != nil {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: err.Error()}
+	}
+	if err := kfrag.ValidatePath(c.Path); err != nil {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: "path out of range"}
+	}
+	if len(c.Coefficients) > kfrag.MaxThreshold {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: "too many coefficients"}
+	}
+	if err := point.ValidatePoints(c.Coefficients...); err != nil {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: err.Error()}
+	}
+	if c.Pi == nil || c.Pi.Z1 == nil || c.Pi.Z2 == nil || c.Pi.Rol == nil {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: "cfrag proof is incomplete"}
+	}
+	if err := point.ValidatePoints(c.Pi.E2, c.Pi.V2, c.Pi.U1, c.Pi.U2); err != nil {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: err.Error()}
+	}
+	// the proof shows E1, V1 use the discrete log of U1, so it must be the U checked against pc
+	if !c.Pi.U1.IsEqual(c.U) {
+		return &ErrInvalidCFrag{Id: c.Id, Reason: "cfrag proof is not for its U"}
+	}
+	return nil
+}
+
+// Verify checks the proof of c against the capsule points E, V it re-encrypts.
 func (c *CFrag) Verify(E, V *point.Point) bool {
-	h, err := curvebn.BytesHash2CurvBN(util.AppendByt(E.Marshal(), c.E1.Marshal(), c.Pi.E2.Marshal(), V.Marshal(), c.V1.Marshal(), c.Pi.V2.Marshal(), point.UPoint().Marshal(), c.Pi.U1.Marshal(), c.Pi.U2.Marshal(), c.Pi.Aux))
-	if err != nil {
+	if c.Validate() != nil || point.ValidatePoints(E, V) != nil {
 		return false
 	}
-	if E.Mul(c.Pi.Rol).IsEqual(c.Pi.E2.Add(c.E1.Mul(h.Int()))) && V.Mul(c.Pi.Rol).IsEqual(c.Pi.V2.Add(c.V1.Mul(h.Int()))) && point.UPoint().Mul(c.Pi.Rol).IsEqual(c.Pi.U2.Add(c.Pi.U1.Mul(h.Int()))) {
+	h := c.challenge(E, V)
+	rol := c.Pi.Rol.Int()
+	if E.Mul(rol).IsEqual(c.Pi.E2.Add(c.E1.Mul(h.Int()))) && V.Mul(rol).IsEqual(c.Pi.V2.Add(c.V1.Mul(h.Int()))) && point.UMul(rol).IsEqual(c.Pi.U2.Add(c.Pi.U1.Mul(h.Int()))) {
 		return true
 	}
 	return false
 }
 
+func (c *CFrag) challenge(E, V *point.Point) *curvebn.Scalar {
+	return ProofChallenge(E, c.E1, c.Pi.E2, V, c.V1, c.Pi.V2, c.Pi.U1, c.Pi.U2, c.Pi.Aux)
+}
+
+// ProofChallenge returns the challenge h of a cfrag proof, the hash of the
+// capsule points, their re-encryptions and commitments, U, U1, U2 and aux.
+func ProofChallenge(E, E1, E2, V, V1, V2, U1, U2 *point.Point, aux []byte) *curvebn.Scalar {
+	return curvebn.HashToScalar(proofDomain, E.Marshal(), E1.Marshal(), E2.Marshal(), V.Marshal(), V1.Marshal(), V2.Marshal(), point.UPoint().Marshal(), U1.Marshal(), U2.Marshal(), aux)
+}
+
+// Marshal encodes c as Id || E1 || V1 || XA || commitment || U || path steps(1) ||
+// path || coefficients(2) || coefficients || Z1 || Z2 || E2 || V2 || U2 || Rol ||
+// aux. U1 is not encoded, it is U.
 func (c *CFrag) Marshal() []byte {
 	var marshal []byte
 	marshal = append(marshal, c.Id.Bytes()...)
 	marshal = append(marshal, c.E1.Marshal()...)
 	marshal = append(marshal, c.V1.Marshal()...)
 	marshal = append(marshal, c.XA.Marshal()...)
+	marshal = append(marshal, c.Commitment...)
+	marshal = append(marshal, c.U.Marshal()...)
+	marshal = append(marshal, byte(len(c.Path)))
+	marshal = append(marshal, kfrag.MarshalPath(c.Path)...)
+	marshal = binary.BigEndian.AppendUint16(marshal, uint16(len(c.Coefficients)))
+	for _, coefficient := range c.Coefficients {
+		marshal = append(marshal, coefficient.Marshal()...)
+	}
+	marshal = append(marshal, c.Pi.Z1.Bytes()...)
+	marshal = append(marshal, c.Pi.Z2.Bytes()...)
+	marshal = append(marshal, c.Pi.E2.Marshal()...)
+	marshal = append(marshal, c.Pi.V2.Marshal()...)
+	marshal = append(marshal, c.Pi.U2.Marshal()...)
+	marshal = append(marshal, c.Pi.Rol.Bytes()...)
+	marshal = append(marshal, c.Pi.Aux...)
 	return marshal
 }
 
+// Unmarshal decodes a cfrag as produced by Marshal, c is only modified if the whole
+// input is valid. The proof is decoded but not verified, see Verify.
 func (c *CFrag) Unmarshal(data []byte) error {
-	bnLen, pLen := curvebn.NewCurveBN(nil).Len(), point.NewPoint().Len()
-	if len(data) == 0 {
-		return errors.New("cfrag data length error")
+	bnLen, pLen := curvebn.ScalarLen, point.NewPoint().Len()
+	pathOff := bnLen + pLen*4 + kfrag.CommitmentLen
+	if len(data) < pathOff+1 {
+		return fmt.Errorf("%w: cfrag data length error", util.ErrMalformedEncoding)
 	}
-	if err := c.Id.FromBytes(data[:bnLen]); err != nil {
-		return err
+	coefficientsOff := pathOff + 1 + 2*int(data[pathOff])
+	if len(data) < coefficientsOff+2 {
+		return fmt.Errorf("%w: cfrag data length error", util.ErrMalformedEncoding)
 	}
-	if err := c.E1.Unmarshal(data[bnLen : bnLen+pLen]); err != nil {
-		return err
+	count := int(binary.BigEndian.Uint16(data[coefficientsOff:]))
+	proofOff := coefficientsOff + 2 + count*pLen
+	auxOff := proofOff + bnLen*3 + pLen*3
+	if len(data) < auxOff {
+		return fmt.Errorf("%w: cfrag data length error", util.ErrMalformedEncoding)
 	}
-	if err := c.V1.Unmarshal(data[bnLen+pLen : bnLen+pLen*2]); err != nil {
+	path, err := kfrag.UnmarshalPath(data[pathOff+1 : coefficientsOff])
+	if err != nil {
 		return err
 	}
-	if err := c.XA.Unmarshal(data[bnLen+pLen*2 : bnLen+pLen*3]); err != nil {
+	var coefficients []*point.Point
+	for i := 0; i < count; i++ {
+		coefficient := point.NewPoint()
+		off := coefficientsOff + 2 + i*pLen
+		if err := coefficient.Unmarshal(data[off : off+pLen]); err != nil {
+			return err
+		}
+		coefficients = append(coefficients, coefficient)
+	}
+	scalars := make([]*curvebn.Scalar, 4)
+	for i, off := range []int{0, proofOff, proofOff + bnLen, proofOff + bnLen*2 + pLen*3} {
+		if scalars[i], err = curvebn.ScalarFromBytes(data[off : off+bnLen]); err != nil {
+			return err
+		}
+	}
+	commitmentOff := bnLen + pLen*3
+	pointOffs := []int{bnLen, bnLen + pLen, bnLen + pLen*2, commitmentOff + kfrag.CommitmentLen,
+		proofOff + bnLen*2, proofOff + bnLen*2 + pLen, proofOff + bnLen*2 + pLen*2}
+	points := make([]*point.Point, len(pointOffs))
+	for i, off := range pointOffs {
+		points[i] = point.NewPoint()
+		if err := points[i].Unmarshal(data[off : off+pLen]); err != nil {
+			return err
+		}
+	}
+	var aux []byte
+	if len(data) > auxOff {
+		aux = append([]byte(nil), data[auxOff:]...)
+	}
+	decoded := &CFrag{
+		Id: scalars[0], E1: points[0], V1: points[1], XA: points[2],
+		Commitment:   append([]byte(nil), data[commitmentOff:commitmentOff+kfrag.CommitmentLen]...),
+		U:            points[3],
+		Path:         path,
+		Coefficients: coefficients,
+		Pi: &Proof{
+			Z1: scalars[1], Z2: scalars[2],
+			E2: points[4], V2: points[5], U1: points[3], U2: points[6],
+			Rol: scalars[3], Aux: aux,
+		},
+	}
+	if err := decoded.Validate(); err != nil {
 		return err
 	}
+	*c = *decoded
 	return nil
 }
 
 type Proof struct {
-	Z1     *curvebn.CurveBN
-	Z2     *big.Int
+	Z1     *curvebn.Scalar
+	Z2     *curvebn.Scalar
 	E2, V2 *point.Point
 	U1, U2 *point.Point
-	Rol    *big.Int
+	Rol    *curvebn.Scalar
 	Aux    []byte
 }
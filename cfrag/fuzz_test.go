@@ -0,0 +1,41 @@
+package cfrag
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+func FuzzCFragUnmarshal(f *testing.F) {
+	k, _ := curvebn.RandomScalar()
+	valid := &CFrag{
+		Id: k, E1: point.BaseMul(k.Int()), V1: point.UMul(k.Int()), XA: point.BaseMul(k.Add(k).Int()),
+		Commitment: make([]byte, kfrag.CommitmentLen), U: point.UMul(k.Int()),
+	}
+	valid.Pi = &Proof{
+		Z1: k, Z2: k, E2: point.BaseMul(k.Int()), V2: point.BaseMul(k.Int()),
+		U1: valid.U, U2: point.UMul(k.Int()), Rol: k,
+	}
+	f.Add(valid.Marshal())
+	valid.Pi.Aux = []byte("aux")
+	f.Add(valid.Marshal())
+	valid.Path = []kfrag.PathStep{{Position: 1}}
+	f.Add(valid.Marshal())
+	valid.Coefficients = []*point.Point{valid.XA, valid.U}
+	f.Add(valid.Marshal())
+	f.Add([]byte{})
+	f.Add(make([]byte, curvebn.ScalarLen))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := NewCFrag()
+		if err := c.Unmarshal(data); err != nil {
+			return
+		}
+		if !bytes.Equal(c.Marshal(), data) {
+			t.Fatal("cfrag encoding is not canonical")
+		}
+	})
+}
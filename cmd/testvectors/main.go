@@ -0,0 +1,40 @@
+// Command testvectors regenerates testvectors/vectors.json and testvectors/umbral.json.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+
+	"github.com/hongyuefan/prencrypt/testvectors"
+)
+
+func main() {
+	out := flag.String("o", "testvectors/vectors.json", "output file")
+	umbralOut := flag.String("umbral", "testvectors/umbral.json", "output file of the umbral vectors")
+	flag.Parse()
+
+	vectors, err := testvectors.GenerateAll()
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := testvectors.Marshal(vectors)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	umbralVectors, err := testvectors.GenerateAllUmbral()
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err = testvectors.MarshalUmbral(umbralVectors)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*umbralOut, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
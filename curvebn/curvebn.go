@@ -2,15 +2,13 @@ package curvebn
 
 import (
 	"crypto/elliptic"
-	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/hongyuefan/prencrypt/point"
 	"github.com/hongyuefan/prencrypt/util"
 )
 
-var mask = []byte{0xff, 0x1, 0x3, 0x7, 0xf, 0x1f, 0x3f, 0x7f}
-
 type CurveBN struct {
 	Curve elliptic.Curve
 	P     []byte
@@ -31,7 +29,7 @@ func (c *CurveBN) Len() int {
 
 func (c *CurveBN) FromBytes(data []byte) error {
 	if len(data) != c.Len() {
-		return errors.New("curveBN data length error")
+		return fmt.Errorf("%w: curveBN data length error", util.ErrMalformedEncoding)
 	}
 	c.P = data
 	return nil
@@ -49,58 +47,27 @@ func (c *CurveBN) String() string {
 	return c.Int().String()
 }
 
+// Convert2CanInverseCurvBN maps c into [1, N-1] so the result is invertible, c is left unchanged.
 func (c *CurveBN) Convert2CanInverseCurvBN() *CurveBN {
-	c.P = new(big.Int).Add(big.NewInt(1), new(big.Int).Mod(new(big.Int).SetBytes(c.P), new(big.Int).Sub(c.Curve.Params().N, big.NewInt(1)))).Bytes()
-	return c
+	return &CurveBN{
+		Curve: c.Curve,
+		P:     new(big.Int).Add(big.NewInt(1), new(big.Int).Mod(new(big.Int).SetBytes(c.P), new(big.Int).Sub(c.Curve.Params().N, big.NewInt(1)))).Bytes(),
+	}
 }
 
 func (c *CurveBN) InverseModCurvBN() *big.Int {
-	return new(big.Int).ModInverse(new(big.Int).SetBytes(c.P), c.Curve.Params().N)
+	return point.ScalarInverse(new(big.Int).SetBytes(c.P))
 }
 
-func PointsHash2CurvBN(points ...*point.Point) (*CurveBN, error) {
-	var byt []byte
-	for _, point := range points {
-		byt = append(byt, point.Marshal()...)
-	}
+var legacyHashDomain = []byte("prencrypt curvebn hash")
 
-	hash, err := util.Hash_class(byt)
-	if err != nil {
-		return nil, err
-	}
-	p, err := generateDByHash(util.Curve, hash)
-	if err != nil {
-		return nil, err
-	}
-	return &CurveBN{Curve: util.Curve, P: p}, nil
+// PointsHash2CurvBN hashes the compressed points to a non-zero CurveBN with
+// HashToScalar, protocol code uses HashToScalar with its own domain instead.
+func PointsHash2CurvBN(points ...*point.Point) (*CurveBN, error) {
+	return &CurveBN{Curve: util.Curve, P: HashPointsToScalar(legacyHashDomain, points...).Bytes()}, nil
 }
 
+// BytesHash2CurvBN is PointsHash2CurvBN for a byte string.
 func BytesHash2CurvBN(byts []byte) (*CurveBN, error) {
-	hash, err := util.Hash_class(byts)
-	if err != nil {
-		return nil, err
-	}
-	p, err := generateDByHash(util.Curve, hash)
-	if err != nil {
-		return nil, err
-	}
-	return &CurveBN{Curve: util.Curve, P: p}, nil
-}
-
-func generateDByHash(curve elliptic.Curve, hash []byte) ([]byte, error) {
-	N := curve.Params().N
-	bitSize := N.BitLen()
-	byteLen := (bitSize + 7) >> 3
-
-	if len(hash) != byteLen {
-		return nil, errors.New("length error")
-	}
-	hash[0] &= mask[bitSize%8]
-
-	hash[1] ^= 0x42
-
-	if new(big.Int).SetBytes(hash).Cmp(N) >= 0 {
-		return nil, errors.New("out of range")
-	}
-	return hash, nil
+	return &CurveBN{Curve: util.Curve, P: HashToScalar(legacyHashDomain, byts).Bytes()}, nil
 }
@@ -0,0 +1,28 @@
+package curvebn
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzCurveBNFromBytes(f *testing.F) {
+	s, _ := RandomScalar()
+	f.Add(s.Bytes())
+	f.Add([]byte{})
+	f.Add(bytes.Repeat([]byte{0xff}, ScalarLen))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := NewCurveBN(nil)
+		if err := c.FromBytes(data); err == nil && !bytes.Equal(c.Bytes(), data) {
+			t.Fatal("curveBN round trip mismatch")
+		}
+
+		s, err := ScalarFromBytes(data)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(s.Bytes(), data) {
+			t.Fatal("scalar encoding is not canonical")
+		}
+	})
+}
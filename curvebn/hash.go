@@ -0,0 +1,55 @@
+package curvebn
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/hongyuefan/prencrypt/internal/ctcurve"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+// HashToScalar hashes parts under the domain separation tag dst to a non-zero
+// Scalar. The input to SHA-256 is
+//
+//	be32(len dst) || dst || be32(counter) || be32(len part) || part || ...
+//
+// two digests with consecutive counters are reduced together modulo N, and the
+// next pair is tried in the negligible case that the result is zero. Every part is
+// length prefixed, so parts cannot shift into each other, and a different dst gives
+// an unrelated function.
+func HashToScalar(dst []byte, parts ...[]byte) *Scalar {
+	var wide [2 * ScalarLen]byte
+	for counter := uint32(0); ; counter += 2 {
+		copy(wide[:ScalarLen], hashBlock(dst, counter, parts))
+		copy(wide[ScalarLen:], hashBlock(dst, counter+1, parts))
+		s := &Scalar{v: ctcurve.ScalarFromWideBytes(&wide)}
+		if !s.IsZero() {
+			return s
+		}
+	}
+}
+
+// HashPointsToScalar is HashToScalar over the compressed encodings of points.
+func HashPointsToScalar(dst []byte, points ...*point.Point) *Scalar {
+	parts := make([][]byte, len(points))
+	for i, p := range points {
+		parts[i] = p.Marshal()
+	}
+	return HashToScalar(dst, parts...)
+}
+
+func hashBlock(dst []byte, counter uint32, parts [][]byte) []byte {
+	h := sha256.New()
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(dst)))
+	h.Write(n[:])
+	h.Write(dst)
+	binary.BigEndian.PutUint32(n[:], counter)
+	h.Write(n[:])
+	for _, part := range parts {
+		binary.BigEndian.PutUint32(n[:], uint32(len(part)))
+		h.Write(n[:])
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
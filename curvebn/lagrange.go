@@ -0,0 +1,65 @@
+package curvebn
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// BatchInverse inverts all scalars with a single modular inversion
+// (Montgomery's trick). It fails if any scalar is zero.
+func BatchInverse(scalars []*Scalar) ([]*Scalar, error) {
+	if len(scalars) == 0 {
+		return nil, nil
+	}
+	// prefix[i] = scalars[0] * ... * scalars[i]
+	prefix := make([]*Scalar, len(scalars))
+	acc := NewScalar(big.NewInt(1))
+	for i, s := range scalars {
+		if s.IsZero() {
+			return nil, fmt.Errorf("%w: cannot invert zero scalar", util.ErrInvalidArgument)
+		}
+		acc = acc.Mul(s)
+		prefix[i] = acc
+	}
+
+	inv := acc.Inverse()
+	inverses := make([]*Scalar, len(scalars))
+	for i := len(scalars) - 1; i > 0; i-- {
+		inverses[i] = inv.Mul(prefix[i-1])
+		inv = inv.Mul(scalars[i])
+	}
+	inverses[0] = inv
+	return inverses, nil
+}
+
+// LagrangeCoefficients returns the coefficients that interpolate a polynomial at
+// zero from its values at xs, using util.LambdaS and one batched inversion.
+// Duplicate xs have no coefficients and are reported as an error.
+func LagrangeCoefficients(xs []*Scalar) ([]*Scalar, error) {
+	shares := make([]*big.Int, len(xs))
+	for i, x := range xs {
+		shares[i] = x.Int()
+	}
+
+	numerators := make([]*Scalar, len(xs))
+	denominators := make([]*Scalar, len(xs))
+	for i := range xs {
+		numerator, denominator, err := util.LambdaS(i, shares)
+		if err != nil {
+			return nil, err
+		}
+		numerators[i], denominators[i] = NewScalar(numerator), NewScalar(denominator)
+	}
+
+	inverses, err := BatchInverse(denominators)
+	if err != nil {
+		return nil, fmt.Errorf("%w: duplicate share index", util.ErrInvalidArgument)
+	}
+	lambdas := make([]*Scalar, len(xs))
+	for i := range xs {
+		lambdas[i] = numerators[i].Mul(inverses[i])
+	}
+	return lambdas, nil
+}
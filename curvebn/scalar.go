@@ -0,0 +1,132 @@
+package curvebn
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/internal/ctcurve"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// ScalarLen is the length of the fixed big-endian encoding of a Scalar.
+const ScalarLen = 32
+
+// Scalar is an immutable integer modulo the curve order N. It is stored as four
+// fixed-width limbs and all arithmetic runs in constant time and returns a new,
+// reduced Scalar.
+type Scalar struct {
+	v ctcurve.Scalar
+}
+
+// NewScalar returns v mod N. big.Int is not constant time, use ScalarFromBytes
+// for secret values.
+func NewScalar(v *big.Int) *Scalar {
+	return &Scalar{v: ctcurve.ScalarFromBig(v)}
+}
+
+// ScalarFromBytes decodes the fixed-length encoding produced by Scalar.Bytes.
+func ScalarFromBytes(b []byte) (*Scalar, error) {
+	if len(b) != ScalarLen {
+		return nil, fmt.Errorf("%w: scalar data length error", util.ErrMalformedEncoding)
+	}
+	var buf [ScalarLen]byte
+	copy(buf[:], b)
+	s := &Scalar{v: ctcurve.ScalarFromBytes(&buf)}
+	// a canonical encoding is unchanged by the reduction
+	if subtle.ConstantTimeCompare(s.Bytes(), b) != 1 {
+		return nil, fmt.Errorf("%w: scalar out of range", util.ErrMalformedEncoding)
+	}
+	return s, nil
+}
+
+// ReduceScalar returns the big-endian value b of at most ScalarLen bytes
+// modulo N, reduced in constant time.
+func ReduceScalar(b []byte) *Scalar {
+	var buf [ScalarLen]byte
+	copy(buf[ScalarLen-len(b):], b)
+	return &Scalar{v: ctcurve.ScalarFromBytes(&buf)}
+}
+
+// ReduceScalarNonZero returns (b mod (N-1)) + 1 for b of at most ScalarLen bytes,
+// the non-zero reduction of a digest used by umbral-pre.
+func ReduceScalarNonZero(b []byte) *Scalar {
+	var buf [ScalarLen]byte
+	copy(buf[ScalarLen-len(b):], b)
+	return &Scalar{v: ctcurve.ScalarFromBytesNonZero(&buf)}
+}
+
+// RandomScalar returns a uniformly random non-zero Scalar.
+func RandomScalar() (*Scalar, error) {
+	return RandomScalarWithRand(nil)
+}
+
+// RandomScalarWithRand is RandomScalar reading randomness from random,
+// nil means crypto/rand. 64 bytes are reduced modulo N, so the bias is negligible.
+func RandomScalarWithRand(random io.Reader) (*Scalar, error) {
+	var buf [2 * ScalarLen]byte
+	for {
+		if _, err := io.ReadFull(util.RandReader(random), buf[:]); err != nil {
+			return nil, err
+		}
+		s := &Scalar{v: ctcurve.ScalarFromWideBytes(&buf)}
+		if !s.IsZero() {
+			return s, nil
+		}
+	}
+}
+
+func (s *Scalar) Add(u *Scalar) *Scalar {
+	return &Scalar{v: s.v.Add(&u.v)}
+}
+
+func (s *Scalar) Sub(u *Scalar) *Scalar {
+	return &Scalar{v: s.v.Sub(&u.v)}
+}
+
+func (s *Scalar) Mul(u *Scalar) *Scalar {
+	return &Scalar{v: s.v.Mul(&u.v)}
+}
+
+func (s *Scalar) Neg() *Scalar {
+	return &Scalar{v: s.v.Neg()}
+}
+
+// Inverse returns s^-1 mod N, the inverse of zero is zero.
+func (s *Scalar) Inverse() *Scalar {
+	return &Scalar{v: s.v.Inverse()}
+}
+
+func (s *Scalar) IsZero() bool {
+	return s.v.IsZero()
+}
+
+func (s *Scalar) IsEqual(u *Scalar) bool {
+	return u != nil && s.v.Equal(&u.v)
+}
+
+// Int returns the value of s as a big.Int, for public values only.
+func (s *Scalar) Int() *big.Int {
+	return s.v.Big()
+}
+
+// Bytes returns the ScalarLen bytes big-endian encoding of s.
+func (s *Scalar) Bytes() []byte {
+	b := s.v.Bytes()
+	return b[:]
+}
+
+func (s *Scalar) Hex() string {
+	return hex.EncodeToString(s.Bytes())
+}
+
+func (s *Scalar) String() string {
+	return s.Int().String()
+}
+
+// Scalar returns the value of c as a Scalar.
+func (c *CurveBN) Scalar() *Scalar {
+	return NewScalar(c.Int())
+}
@@ -0,0 +1,84 @@
+package curvebn
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/hongyuefan/prencrypt/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalar(t *testing.T) {
+	a, err := RandomScalar()
+	if !assert.NoError(t, err) {
+		return
+	}
+	b, err := RandomScalar()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, a.Add(b).Sub(b).IsEqual(a))
+	assert.True(t, a.Add(a.Neg()).IsZero())
+	assert.True(t, a.Mul(a.Inverse()).IsEqual(NewScalar(big.NewInt(1))))
+
+	small := NewScalar(big.NewInt(1))
+	assert.Len(t, small.Bytes(), ScalarLen)
+	decoded, err := ScalarFromBytes(small.Bytes())
+	assert.NoError(t, err)
+	assert.True(t, decoded.IsEqual(small))
+
+	_, err = ScalarFromBytes(util.Curve.Params().N.Bytes())
+	assert.Error(t, err)
+
+	// ReduceScalar reduces instead of rejecting
+	assert.True(t, ReduceScalar(util.Curve.Params().N.Bytes()).IsZero())
+	assert.True(t, ReduceScalar([]byte{1}).IsEqual(small))
+
+	// ReduceScalarNonZero maps b to (b mod (N-1)) + 1
+	nMinus1 := new(big.Int).Sub(util.Curve.Params().N, big.NewInt(1))
+	assert.True(t, ReduceScalarNonZero(nil).IsEqual(small))
+	assert.True(t, ReduceScalarNonZero(nMinus1.Bytes()).IsEqual(small))
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	want := new(big.Int).Add(new(big.Int).Mod(max, nMinus1), big.NewInt(1))
+	assert.Equal(t, want, ReduceScalarNonZero(max.Bytes()).Int())
+}
+
+func TestConvert2CanInverseCurvBN(t *testing.T) {
+	c := NewCurveBN(new(big.Int).Sub(util.Curve.Params().N, big.NewInt(1)).Bytes())
+	before := c.String()
+	assert.Equal(t, "1", c.Convert2CanInverseCurvBN().String())
+	assert.Equal(t, before, c.String())
+}
+
+func TestLagrangeCoefficients(t *testing.T) {
+	// f(x) = 5 + 3x, f(1) = 8, f(2) = 11
+	xs := []*Scalar{NewScalar(big.NewInt(1)), NewScalar(big.NewInt(2))}
+	lambdas, err := LagrangeCoefficients(xs)
+	if !assert.NoError(t, err) {
+		return
+	}
+	secret := lambdas[0].Mul(NewScalar(big.NewInt(8))).Add(lambdas[1].Mul(NewScalar(big.NewInt(11))))
+	assert.Equal(t, "5", secret.String())
+
+	_, err = LagrangeCoefficients([]*Scalar{xs[0], xs[0]})
+	assert.True(t, errors.Is(err, util.ErrInvalidArgument))
+}
+
+func TestHashToScalar(t *testing.T) {
+	data := make([]byte, 64)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	h := HashToScalar([]byte("a"), data)
+	assert.False(t, h.IsZero())
+	assert.NotEqual(t, data[:ScalarLen], h.Bytes(), "hash must not return its input")
+	assert.True(t, h.IsEqual(HashToScalar([]byte("a"), data)))
+
+	// domain, content and the split into parts all change the result
+	assert.False(t, h.IsEqual(HashToScalar([]byte("b"), data)))
+	assert.False(t, h.IsEqual(HashToScalar([]byte("a"), data[:63])))
+	assert.False(t, h.IsEqual(HashToScalar([]byte("a"), data[:32], data[32:])))
+	assert.False(t, HashToScalar([]byte("a"), []byte{1}, nil).IsEqual(HashToScalar([]byte("a"), nil, []byte{1})))
+}
@@ -0,0 +1,70 @@
+package prencrypt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/symcrypt"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// Encrypt encapsulates a fresh shared key for alicePub and encrypts plainText with it.
+// The serialized capsule and the optional metadata are bound into the AEAD tag, so the
+// ciphertext only decrypts together with the capsule it was produced with.
+func Encrypt(alicePub *keys.PublicKey, plainText, metadata []byte) ([]byte, *capsule.Capsule, error) {
+	return EncryptWithRand(nil, alicePub, plainText, metadata)
+}
+
+// EncryptWithRand is Encrypt reading randomness from random, nil means crypto/rand.
+func EncryptWithRand(random io.Reader, alicePub *keys.PublicKey, plainText, metadata []byte) ([]byte, *capsule.Capsule, error) {
+	sharedKey, capsule, err := EncapsulateWithRand(random, alicePub)
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherText, err := symcrypt.EncryptAesWithRand(random, sharedKey, plainText, associatedData(capsule, metadata))
+	if err != nil {
+		return nil, nil, err
+	}
+	return cipherText, capsule, nil
+}
+
+// DecryptOriginal decrypts a ciphertext produced by Encrypt with Alice's private key.
+func DecryptOriginal(alicePriv *keys.PrivateKey, capsule *capsule.Capsule, cipherText, metadata []byte) ([]byte, error) {
+	if alicePriv == nil {
+		return nil, fmt.Errorf("%w: private key is nil", ErrInvalidKey)
+	}
+	if capsule == nil {
+		return nil, fmt.Errorf("%w: capsule is nil", ErrInvalidCapsule)
+	}
+	sharedKey, err := DecapsulateOriginal(alicePriv, capsule)
+	if err != nil {
+		return nil, err
+	}
+	return symcrypt.DecryptAesWithAD(sharedKey, cipherText, associatedData(capsule, metadata))
+}
+
+// DecryptReencrypted decrypts a ciphertext produced by Encrypt with Bob's private key and
+// the cfrags the proxies re-encrypted capsule into, see DecapsulateFrags.
+func DecryptReencrypted(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, capsule *capsule.Capsule, cfrags []*cfrag.CFrag, cipherText, metadata []byte) ([]byte, error) {
+	if capsule == nil {
+		return nil, fmt.Errorf("%w: capsule is nil", ErrInvalidCapsule)
+	}
+	sharedKey, err := DecapsulateFrags(privBob, pubAlice, pc, capsule, cfrags)
+	if err != nil {
+		return nil, err
+	}
+	return symcrypt.DecryptAesWithAD(sharedKey, cipherText, associatedData(capsule, metadata))
+}
+
+// associatedData length-prefixes the capsule so capsule and metadata bytes cannot be shifted into each other.
+func associatedData(capsule *capsule.Capsule, metadata []byte) []byte {
+	capByt := capsule.Marshal()
+	capLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(capLen, uint16(len(capByt)))
+	return util.AppendByt(capLen, capByt, metadata)
+}
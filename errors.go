@@ -0,0 +1,43 @@
+package prencrypt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/symcrypt"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// The errors returned by this package and its subpackages wrap one of these
+// values, so callers can use errors.Is and errors.As to tell a malformed request
+// from a cryptographic failure.
+var (
+	ErrInvalidCapsule    = capsule.ErrInvalidCapsule
+	ErrInvalidKFrag      = kfrag.ErrInvalidKFrag
+	ErrInvalidKey        = keys.ErrInvalidKey
+	ErrInvalidSignature  = keys.ErrInvalidSignature
+	ErrMalformedEncoding = util.ErrMalformedEncoding
+	ErrInvalidArgument   = util.ErrInvalidArgument
+	ErrDecryptionFailed  = symcrypt.ErrDecryptionFailed
+	ErrThresholdNotMet   = errors.New("not enough cfrags to meet the threshold")
+	ErrHopLimit          = errors.New("capsule cannot be forwarded again")
+)
+
+// ErrInvalidCFrag is returned for a cfrag that is incomplete, does not verify or
+// does not belong with the other cfrags, see cfrag.ErrInvalidCFrag.
+type ErrInvalidCFrag = cfrag.ErrInvalidCFrag
+
+// verifyCapsule is capsule.Verify returning an error wrapping ErrInvalidCapsule.
+func verifyCapsule(c *capsule.Capsule) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if !c.Verify() {
+		return fmt.Errorf("%w: capsule verification failed", ErrInvalidCapsule)
+	}
+	return nil
+}
@@ -1,9 +1,16 @@
 module github.com/hongyuefan/prencrypt
 
-go 1.14
+go 1.18
 
 require (
 	github.com/fomichev/secp256k1 v0.0.0-20180413221153-00116ff8c62f
 	github.com/stretchr/testify v1.5.1
 	golang.org/x/crypto v0.0.0-20200406173513-056763e48d71
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
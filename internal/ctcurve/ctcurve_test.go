@@ -0,0 +1,144 @@
+package ctcurve
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/fomichev/secp256k1"
+	"github.com/stretchr/testify/assert"
+)
+
+var curve = secp256k1.SECP256K1()
+
+func TestFieldMul(t *testing.T) {
+	P := curve.Params().P
+	for i := 0; i < 1000; i++ {
+		a, _ := rand.Int(rand.Reader, P)
+		b, _ := rand.Int(rand.Reader, P)
+		if i == 0 {
+			a.Sub(P, big.NewInt(1))
+			b.Sub(P, big.NewInt(1))
+		}
+		fa, fb := feFromBig(a), feFromBig(b)
+		want := new(big.Int).Mod(new(big.Int).Mul(a, b), P)
+		got := feMul(&fa, &fb)
+		assertIntEqual(t, want, feToBig(&got))
+
+		want = new(big.Int).Mod(new(big.Int).Add(a, b), P)
+		got = feAdd(&fa, &fb)
+		assertIntEqual(t, want, feToBig(&got))
+
+		want = new(big.Int).Mod(new(big.Int).Sub(a, b), P)
+		got = feSub(&fa, &fb)
+		assertIntEqual(t, want, feToBig(&got))
+	}
+	a, _ := rand.Int(rand.Reader, P)
+	fa := feFromBig(a)
+	inv := feInv(&fa)
+	assertIntEqual(t, new(big.Int).ModInverse(a, P), feToBig(&inv))
+}
+
+func sc(k *big.Int) *Scalar {
+	s := ScalarFromBig(k)
+	return &s
+}
+
+func TestScalarArithmetic(t *testing.T) {
+	N := curve.Params().N
+	mod := func(v *big.Int) *big.Int { return v.Mod(v, N) }
+	for i := 0; i < 100; i++ {
+		a, _ := rand.Int(rand.Reader, N)
+		b, _ := rand.Int(rand.Reader, N)
+		if i == 0 {
+			a.Sub(N, big.NewInt(1))
+		}
+		sa, sb := sc(a), sc(b)
+
+		r := sa.Add(sb)
+		assertIntEqual(t, mod(new(big.Int).Add(a, b)), r.Big())
+		r = sa.Sub(sb)
+		assertIntEqual(t, mod(new(big.Int).Sub(a, b)), r.Big())
+		r = sa.Neg()
+		assertIntEqual(t, mod(new(big.Int).Neg(a)), r.Big())
+		r = sa.Mul(sb)
+		assertIntEqual(t, mod(new(big.Int).Mul(a, b)), r.Big())
+		r = sa.Inverse()
+		assertIntEqual(t, new(big.Int).ModInverse(a, N), r.Big())
+
+		var wide [64]byte
+		rand.Read(wide[:])
+		r = ScalarFromWideBytes(&wide)
+		assertIntEqual(t, mod(new(big.Int).SetBytes(wide[:])), r.Big())
+	}
+
+	var max [32]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	r := ScalarFromBytes(&max)
+	assertIntEqual(t, new(big.Int).Sub(new(big.Int).SetBytes(max[:]), N), r.Big())
+	assert.True(t, sc(N).IsZero())
+	assert.True(t, sc(big.NewInt(-1)).Equal(sc(new(big.Int).Sub(N, big.NewInt(1)))))
+}
+
+func TestScalarMult(t *testing.T) {
+	N := curve.Params().N
+	for i := 0; i < 50; i++ {
+		k, _ := rand.Int(rand.Reader, N)
+		if i == 0 {
+			k.Sub(N, big.NewInt(1))
+		}
+		x, y := ScalarBaseMult(sc(k))
+		wx, wy := curve.ScalarBaseMult(k.Bytes())
+		assertIntEqual(t, wx, x)
+		assertIntEqual(t, wy, y)
+
+		m, _ := rand.Int(rand.Reader, N)
+		x2, y2 := ScalarMult(x, y, sc(m))
+		wx, wy = curve.ScalarMult(wx, wy, m.Bytes())
+		assertIntEqual(t, wx, x2)
+		assertIntEqual(t, wy, y2)
+
+		sx, sy := Add(x, y, x2, y2)
+		wx, wy = curve.Add(x, y, x2, y2)
+		assertIntEqual(t, wx, sx)
+		assertIntEqual(t, wy, sy)
+	}
+
+	x, y := ScalarBaseMult(sc(curve.Params().N))
+	assert.Equal(t, 0, x.Sign())
+	assert.Equal(t, 0, y.Sign())
+
+	gx, gy := ScalarBaseMult(sc(big.NewInt(1)))
+	dx, dy := Add(gx, gy, gx, gy)
+	wx, wy := ScalarBaseMult(sc(big.NewInt(2)))
+	assertIntEqual(t, wx, dx)
+	assertIntEqual(t, wy, dy)
+
+	x, y = Add(gx, gy, gx, new(big.Int).Sub(curve.Params().P, gy))
+	assert.Equal(t, 0, x.Sign())
+	assert.Equal(t, 0, y.Sign())
+}
+
+func assertIntEqual(t *testing.T, want, got *big.Int) {
+	assert.Zero(t, want.Cmp(got), "want %v got %v", want, got)
+}
+
+func TestMultiScalarMult(t *testing.T) {
+	N := curve.Params().N
+	var xs, ys []*big.Int
+	var ks []Scalar
+	wx, wy := new(big.Int), new(big.Int)
+	for i := 0; i < 5; i++ {
+		k, _ := rand.Int(rand.Reader, N)
+		m, _ := rand.Int(rand.Reader, N)
+		x, y := ScalarBaseMult(sc(k))
+		xs, ys, ks = append(xs, x), append(ys, y), append(ks, *sc(m))
+		px, py := ScalarMult(x, y, sc(m))
+		wx, wy = Add(wx, wy, px, py)
+	}
+	x, y := MultiScalarMult(xs, ys, ks)
+	assertIntEqual(t, wx, x)
+	assertIntEqual(t, wy, y)
+}
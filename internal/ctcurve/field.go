@@ -0,0 +1,199 @@
+// Package ctcurve implements constant-time arithmetic on secp256k1.
+//
+// Field elements and scalars are four 64-bit little-endian limbs that are always
+// kept fully reduced. None of the operations branch on or index memory by secret
+// data; the only data-dependent branches are on public values such as whether a
+// final result is the point at infinity.
+package ctcurve
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// fieldElement is an integer modulo p = 2^256 - 2^32 - 977.
+type fieldElement [4]uint64
+
+var (
+	fieldP = fieldElement{0xFFFFFFFEFFFFFC2F, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF}
+
+	// 2^256 mod p
+	fieldC uint64 = 0x1000003D1
+
+	fieldOne = fieldElement{1, 0, 0, 0}
+	// 3*b, the curve constant used by the complete formulas
+	fieldB3 = fieldElement{21, 0, 0, 0}
+)
+
+// mask returns all ones when b == 1 and zero when b == 0.
+func mask(b uint64) uint64 {
+	return -b
+}
+
+func selectLimbs(dst *[4]uint64, src *[4]uint64, m uint64) {
+	for i := range dst {
+		dst[i] ^= m & (dst[i] ^ src[i])
+	}
+}
+
+// subtractOnce returns a - m if a >= m and a otherwise, with carry being a fifth limb of a.
+func subtractOnce(a *[4]uint64, carry uint64, m *[4]uint64) [4]uint64 {
+	var d [4]uint64
+	var borrow uint64
+	d[0], borrow = bits.Sub64(a[0], m[0], 0)
+	d[1], borrow = bits.Sub64(a[1], m[1], borrow)
+	d[2], borrow = bits.Sub64(a[2], m[2], borrow)
+	d[3], borrow = bits.Sub64(a[3], m[3], borrow)
+	// keep a only when it had no fifth limb and the subtraction borrowed
+	_, borrow = bits.Sub64(carry, 0, borrow)
+	r := *a
+	selectLimbs(&r, &d, mask(1^borrow))
+	return r
+}
+
+func addMod(a, b, m *[4]uint64) [4]uint64 {
+	var s [4]uint64
+	var carry uint64
+	s[0], carry = bits.Add64(a[0], b[0], 0)
+	s[1], carry = bits.Add64(a[1], b[1], carry)
+	s[2], carry = bits.Add64(a[2], b[2], carry)
+	s[3], carry = bits.Add64(a[3], b[3], carry)
+	return subtractOnce(&s, carry, m)
+}
+
+func subMod(a, b, m *[4]uint64) [4]uint64 {
+	var d [4]uint64
+	var borrow uint64
+	d[0], borrow = bits.Sub64(a[0], b[0], 0)
+	d[1], borrow = bits.Sub64(a[1], b[1], borrow)
+	d[2], borrow = bits.Sub64(a[2], b[2], borrow)
+	d[3], borrow = bits.Sub64(a[3], b[3], borrow)
+	var c [4]uint64
+	m2 := mask(borrow)
+	var carry uint64
+	c[0], carry = bits.Add64(d[0], m[0]&m2, 0)
+	c[1], carry = bits.Add64(d[1], m[1]&m2, carry)
+	c[2], carry = bits.Add64(d[2], m[2]&m2, carry)
+	c[3], _ = bits.Add64(d[3], m[3]&m2, carry)
+	return c
+}
+
+func feAdd(a, b *fieldElement) fieldElement {
+	return addMod((*[4]uint64)(a), (*[4]uint64)(b), (*[4]uint64)(&fieldP))
+}
+
+func feSub(a, b *fieldElement) fieldElement {
+	return subMod((*[4]uint64)(a), (*[4]uint64)(b), (*[4]uint64)(&fieldP))
+}
+
+// mul512 returns the full 512-bit product of a and b.
+func mul512(a, b *[4]uint64) [8]uint64 {
+	var t [8]uint64
+	for i := 0; i < 4; i++ {
+		var carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[i], b[j])
+			var c uint64
+			lo, c = bits.Add64(lo, t[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			t[i+j] = lo
+			carry = hi
+		}
+		t[i+4] = carry
+	}
+	return t
+}
+
+func feMul(a, b *fieldElement) fieldElement {
+	t := mul512((*[4]uint64)(a), (*[4]uint64)(b))
+
+	// fold the high half using 2^256 = fieldC mod p
+	var r [4]uint64
+	var carry uint64
+	for i := 0; i < 4; i++ {
+		hi, lo := bits.Mul64(t[i+4], fieldC)
+		var c uint64
+		lo, c = bits.Add64(lo, t[i], 0)
+		hi += c
+		lo, c = bits.Add64(lo, carry, 0)
+		hi += c
+		r[i] = lo
+		carry = hi
+	}
+
+	// carry < 2^34, fold it once more
+	hi, lo := bits.Mul64(carry, fieldC)
+	var c uint64
+	r[0], c = bits.Add64(r[0], lo, 0)
+	r[1], c = bits.Add64(r[1], hi, c)
+	r[2], c = bits.Add64(r[2], 0, c)
+	r[3], c = bits.Add64(r[3], 0, c)
+
+	// a final carry leaves a small value in r, adding fieldC cannot overflow again
+	r[0], c = bits.Add64(r[0], fieldC&mask(c), 0)
+	r[1], c = bits.Add64(r[1], 0, c)
+	r[2], c = bits.Add64(r[2], 0, c)
+	r[3], _ = bits.Add64(r[3], 0, c)
+
+	return subtractOnce(&r, 0, (*[4]uint64)(&fieldP))
+}
+
+func feSquare(a *fieldElement) fieldElement {
+	return feMul(a, a)
+}
+
+// feInv returns a^(p-2), which is a^-1 for a != 0 and 0 for a == 0.
+func feInv(a *fieldElement) fieldElement {
+	e := fieldP
+	e[0] -= 2
+	return fePow(a, &e)
+}
+
+// fePow raises a to a public exponent.
+func fePow(a *fieldElement, e *fieldElement) fieldElement {
+	r := fieldOne
+	for i := 255; i >= 0; i-- {
+		r = feSquare(&r)
+		if (e[i/64]>>(uint(i)%64))&1 == 1 {
+			r = feMul(&r, a)
+		}
+	}
+	return r
+}
+
+func feIsZero(a *fieldElement) bool {
+	return a[0]|a[1]|a[2]|a[3] == 0
+}
+
+func feFromBig(x *big.Int) fieldElement {
+	return fieldElement(limbsFromBytes(x.Bytes()))
+}
+
+func feToBig(a *fieldElement) *big.Int {
+	return new(big.Int).SetBytes(limbsToBytes((*[4]uint64)(a)))
+}
+
+// limbsFromBytes reads up to 32 big-endian bytes.
+func limbsFromBytes(b []byte) [4]uint64 {
+	var buf [32]byte
+	copy(buf[32-len(b):], b)
+	var l [4]uint64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			l[3-i] = l[3-i]<<8 | uint64(buf[i*8+j])
+		}
+	}
+	return l
+}
+
+func limbsToBytes(l *[4]uint64) []byte {
+	b := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			b[i*8+j] = byte(l[3-i] >> (56 - 8*uint(j)))
+		}
+	}
+	return b
+}
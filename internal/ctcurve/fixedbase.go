@@ -0,0 +1,52 @@
+package ctcurve
+
+import (
+	"math/big"
+	"sync"
+)
+
+// FixedBase holds j * 16^i * P for every window i and digit j, so that k*P takes
+// 64 additions and no doublings.
+type FixedBase struct {
+	table [64]lookupTable
+}
+
+// NewFixedBase precomputes the tables for (x, y), roughly the cost of four scalar
+// multiplications.
+func NewFixedBase(x, y *big.Int) *FixedBase {
+	f := new(FixedBase)
+	base := fromAffine(x, y)
+	for i := range f.table {
+		f.table[i] = *newLookupTable(&base)
+		base = base.double()
+		base = base.double()
+		base = base.double()
+		base = base.double()
+	}
+	return f
+}
+
+// Mul returns k*P in constant time.
+func (f *FixedBase) Mul(k *Scalar) (*big.Int, *big.Int) {
+	d := nibbles(&k.s)
+	r := identity
+	for i := range f.table {
+		// nibbles are most significant first
+		q := f.table[i].lookup(d[63-i])
+		r = r.add(&q)
+	}
+	return r.toAffine()
+}
+
+var (
+	baseTable     *FixedBase
+	baseTableOnce sync.Once
+)
+
+// ScalarBaseMult returns k*G using a table built on first use.
+func ScalarBaseMult(k *Scalar) (*big.Int, *big.Int) {
+	baseTableOnce.Do(func() {
+		baseTable = NewFixedBase(baseX, baseY)
+	})
+	return baseTable.Mul(k)
+}
@@ -0,0 +1,158 @@
+package ctcurve
+
+import (
+	"crypto/subtle"
+	"math/big"
+)
+
+// projectivePoint is (X:Y:Z) with x = X/Z, y = Y/Z; the identity is (0:1:0).
+// The complete formulas of Renes, Costello and Batina (ePrint 2015/1060) for a = 0
+// handle the identity, doubling and P + (-P) without special cases.
+type projectivePoint struct {
+	X, Y, Z fieldElement
+}
+
+var (
+	identity = projectivePoint{Y: fieldOne}
+
+	baseX, _ = new(big.Int).SetString("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", 16)
+	baseY, _ = new(big.Int).SetString("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8", 16)
+)
+
+// fromAffine maps (0, 0), the encoding crypto/elliptic uses for infinity, to the identity.
+func fromAffine(x, y *big.Int) projectivePoint {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return identity
+	}
+	return projectivePoint{X: feFromBig(x), Y: feFromBig(y), Z: fieldOne}
+}
+
+func (p *projectivePoint) toAffine() (*big.Int, *big.Int) {
+	if feIsZero(&p.Z) {
+		return new(big.Int), new(big.Int)
+	}
+	zInv := feInv(&p.Z)
+	x := feMul(&p.X, &zInv)
+	y := feMul(&p.Y, &zInv)
+	return feToBig(&x), feToBig(&y)
+}
+
+// add is algorithm 7 of the RCB paper.
+func (p *projectivePoint) add(q *projectivePoint) projectivePoint {
+	t0 := feMul(&p.X, &q.X)
+	t1 := feMul(&p.Y, &q.Y)
+	t2 := feMul(&p.Z, &q.Z)
+	t3 := feAdd(&p.X, &p.Y)
+	t4 := feAdd(&q.X, &q.Y)
+	t3 = feMul(&t3, &t4)
+	t4 = feAdd(&t0, &t1)
+	t3 = feSub(&t3, &t4)
+	t4 = feAdd(&p.Y, &p.Z)
+	x3 := feAdd(&q.Y, &q.Z)
+	t4 = feMul(&t4, &x3)
+	x3 = feAdd(&t1, &t2)
+	t4 = feSub(&t4, &x3)
+	x3 = feAdd(&p.X, &p.Z)
+	y3 := feAdd(&q.X, &q.Z)
+	x3 = feMul(&x3, &y3)
+	y3 = feAdd(&t0, &t2)
+	y3 = feSub(&x3, &y3)
+	x3 = feAdd(&t0, &t0)
+	t0 = feAdd(&x3, &t0)
+	t2 = feMul(&fieldB3, &t2)
+	z3 := feAdd(&t1, &t2)
+	t1 = feSub(&t1, &t2)
+	y3 = feMul(&fieldB3, &y3)
+	x3 = feMul(&t4, &y3)
+	t2 = feMul(&t3, &t1)
+	x3 = feSub(&t2, &x3)
+	y3 = feMul(&y3, &t0)
+	t1 = feMul(&t1, &z3)
+	y3 = feAdd(&t1, &y3)
+	t0 = feMul(&t0, &t3)
+	z3 = feMul(&z3, &t4)
+	z3 = feAdd(&z3, &t0)
+	return projectivePoint{X: x3, Y: y3, Z: z3}
+}
+
+// double is algorithm 9 of the RCB paper.
+func (p *projectivePoint) double() projectivePoint {
+	t0 := feSquare(&p.Y)
+	z3 := feAdd(&t0, &t0)
+	z3 = feAdd(&z3, &z3)
+	z3 = feAdd(&z3, &z3)
+	t1 := feMul(&p.Y, &p.Z)
+	t2 := feSquare(&p.Z)
+	t2 = feMul(&fieldB3, &t2)
+	x3 := feMul(&t2, &z3)
+	y3 := feAdd(&t0, &t2)
+	z3 = feMul(&t1, &z3)
+	t1 = feAdd(&t2, &t2)
+	t2 = feAdd(&t1, &t2)
+	t0 = feSub(&t0, &t2)
+	y3 = feMul(&t0, &y3)
+	y3 = feAdd(&x3, &y3)
+	t1 = feMul(&p.X, &p.Y)
+	x3 = feMul(&t0, &t1)
+	x3 = feAdd(&x3, &x3)
+	return projectivePoint{X: x3, Y: y3, Z: z3}
+}
+
+// selectPoint copies q into p when m is all ones and leaves p unchanged when m is zero.
+func (p *projectivePoint) selectPoint(q *projectivePoint, m uint64) {
+	selectLimbs((*[4]uint64)(&p.X), (*[4]uint64)(&q.X), m)
+	selectLimbs((*[4]uint64)(&p.Y), (*[4]uint64)(&q.Y), m)
+	selectLimbs((*[4]uint64)(&p.Z), (*[4]uint64)(&q.Z), m)
+}
+
+// lookupTable holds 0*P, 1*P, ..., 15*P.
+type lookupTable [16]projectivePoint
+
+func newLookupTable(p *projectivePoint) *lookupTable {
+	t := new(lookupTable)
+	t[0] = identity
+	t[1] = *p
+	for i := 2; i < 16; i++ {
+		if i%2 == 0 {
+			t[i] = t[i/2].double()
+		} else {
+			t[i] = t[i-1].add(p)
+		}
+	}
+	return t
+}
+
+// lookup returns t[idx] reading every entry, so the access pattern does not depend on idx.
+func (t *lookupTable) lookup(idx uint8) projectivePoint {
+	r := identity
+	for i := range t {
+		m := uint64(subtle.ConstantTimeByteEq(uint8(i), idx))
+		r.selectPoint(&t[i], mask(m))
+	}
+	return r
+}
+
+// nibbles returns the 64 four-bit digits of k, most significant first.
+func nibbles(k *scalar) [64]uint8 {
+	var d [64]uint8
+	b := limbsToBytes((*[4]uint64)(k))
+	for i, v := range b {
+		d[2*i] = v >> 4
+		d[2*i+1] = v & 0xf
+	}
+	return d
+}
+
+// ScalarMult returns k*(x, y). (0, 0) stands for infinity both as input and as
+// result.
+func ScalarMult(x, y *big.Int, k *Scalar) (*big.Int, *big.Int) {
+	return NewMultiplier(k).Mul(x, y)
+}
+
+// Add returns (x1, y1) + (x2, y2).
+func Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	p := fromAffine(x1, y1)
+	q := fromAffine(x2, y2)
+	r := p.add(&q)
+	return r.toAffine()
+}
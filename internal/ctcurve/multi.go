@@ -0,0 +1,31 @@
+package ctcurve
+
+import "math/big"
+
+// MultiScalarMult returns sum(ks[i] * (xs[i], ys[i])) using Straus' method: the
+// 256 doublings are shared between all terms and each term costs 64 additions
+// plus its own 16 entry table. Like ScalarMult it runs in constant time.
+func MultiScalarMult(xs, ys []*big.Int, ks []Scalar) (*big.Int, *big.Int) {
+	tables := make([]*lookupTable, len(ks))
+	digits := make([][64]uint8, len(ks))
+	for i := range ks {
+		p := fromAffine(xs[i], ys[i])
+		tables[i] = newLookupTable(&p)
+		digits[i] = nibbles(&ks[i].s)
+	}
+
+	r := identity
+	for w := 0; w < 64; w++ {
+		if w > 0 {
+			r = r.double()
+			r = r.double()
+			r = r.double()
+			r = r.double()
+		}
+		for i := range tables {
+			q := tables[i].lookup(digits[i][w])
+			r = r.add(&q)
+		}
+	}
+	return r.toAffine()
+}
@@ -0,0 +1,32 @@
+package ctcurve
+
+import "math/big"
+
+// Multiplier holds the window decomposition of a scalar that is multiplied with
+// many different points.
+type Multiplier struct {
+	digits [64]uint8
+}
+
+func NewMultiplier(k *Scalar) *Multiplier {
+	return &Multiplier{digits: nibbles(&k.s)}
+}
+
+// Mul returns k*(x, y) with a fixed window: 256 doublings and 64 additions
+// regardless of the value of k.
+func (m *Multiplier) Mul(x, y *big.Int) (*big.Int, *big.Int) {
+	p := fromAffine(x, y)
+	table := newLookupTable(&p)
+	r := identity
+	for i, d := range m.digits {
+		if i > 0 {
+			r = r.double()
+			r = r.double()
+			r = r.double()
+			r = r.double()
+		}
+		q := table.lookup(d)
+		r = r.add(&q)
+	}
+	return r.toAffine()
+}
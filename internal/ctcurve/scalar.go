@@ -0,0 +1,175 @@
+package ctcurve
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// scalar is an integer modulo the group order N, in Montgomery form where noted.
+type scalar [4]uint64
+
+var (
+	orderN = scalar{0xBFD25E8CD0364141, 0xBAAEDCE6AF48A03B, 0xFFFFFFFFFFFFFFFE, 0xFFFFFFFFFFFFFFFF}
+
+	// -N^-1 mod 2^64
+	orderNPrime uint64
+	// 2^512 mod N, converts into Montgomery form
+	orderR2 scalar
+
+	orderNBig *big.Int
+)
+
+func init() {
+	// Newton iteration for N^-1 mod 2^64
+	inv := uint64(1)
+	for i := 0; i < 6; i++ {
+		inv *= 2 - orderN[0]*inv
+	}
+	orderNPrime = -inv
+
+	orderNBig = new(big.Int).SetBytes(limbsToBytes((*[4]uint64)(&orderN)))
+	r2 := new(big.Int).Lsh(big.NewInt(1), 512)
+	orderR2 = scalar(limbsFromBytes(r2.Mod(r2, orderNBig).Bytes()))
+}
+
+// montMul returns a*b*2^-256 mod N.
+func montMul(a, b *scalar) scalar {
+	var t [6]uint64
+	for i := 0; i < 4; i++ {
+		var C, c uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[j], b[i])
+			lo, c = bits.Add64(lo, t[j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, C, 0)
+			hi += c
+			t[j] = lo
+			C = hi
+		}
+		t[4], c = bits.Add64(t[4], C, 0)
+		t[5] = c
+
+		m := t[0] * orderNPrime
+		hi, lo := bits.Mul64(m, orderN[0])
+		_, c = bits.Add64(lo, t[0], 0)
+		C = hi + c
+		for j := 1; j < 4; j++ {
+			hi, lo = bits.Mul64(m, orderN[j])
+			lo, c = bits.Add64(lo, t[j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, C, 0)
+			hi += c
+			t[j-1] = lo
+			C = hi
+		}
+		t[3], c = bits.Add64(t[4], C, 0)
+		t[4] = t[5] + c
+	}
+	r := [4]uint64{t[0], t[1], t[2], t[3]}
+	return scalar(subtractOnce(&r, t[4], (*[4]uint64)(&orderN)))
+}
+
+// Scalar is an integer modulo N, always fully reduced and in normal (not
+// Montgomery) form. Its arithmetic runs in time independent of the values.
+type Scalar struct {
+	s scalar
+}
+
+// ScalarFromBig reduces k modulo N. The value is always reduced and written at
+// full width, so the conversion does not branch on the size or sign of k, but
+// big.Int arithmetic itself is not constant time; prefer ScalarFromBytes for
+// secret values.
+func ScalarFromBig(k *big.Int) Scalar {
+	var buf [32]byte
+	new(big.Int).Mod(k, orderNBig).FillBytes(buf[:])
+	return ScalarFromBytes(&buf)
+}
+
+// ScalarFromBytes reads 32 big-endian bytes and reduces them modulo N, one
+// subtraction is enough as 2^256 < 2N.
+func ScalarFromBytes(b *[32]byte) Scalar {
+	l := limbsFromBytes(b[:])
+	return Scalar{scalar(subtractOnce(&l, 0, (*[4]uint64)(&orderN)))}
+}
+
+// ScalarFromBytesNonZero maps 32 big-endian bytes to (b mod (N-1)) + 1, which is
+// never zero. As with ScalarFromBytes one subtraction reduces b, 2^256 < 2(N-1).
+func ScalarFromBytesNonZero(b *[32]byte) Scalar {
+	l := limbsFromBytes(b[:])
+	m := [4]uint64(orderN)
+	m[0]--
+	one := [4]uint64{1, 0, 0, 0}
+	r := subtractOnce(&l, 0, &m)
+	return Scalar{scalar(addMod(&r, &one, (*[4]uint64)(&orderN)))}
+}
+
+// ScalarFromWideBytes reduces 64 big-endian bytes modulo N, so that a uniform
+// input gives a scalar with negligible bias.
+func ScalarFromWideBytes(b *[64]byte) Scalar {
+	var hi, lo [32]byte
+	copy(hi[:], b[:32])
+	copy(lo[:], b[32:])
+	h, l := ScalarFromBytes(&hi), ScalarFromBytes(&lo)
+	// montMul(h, R2) = h*2^256 mod N
+	h.s = montMul(&h.s, &orderR2)
+	return h.Add(&l)
+}
+
+// Bytes returns the 32 byte big-endian encoding of s.
+func (s *Scalar) Bytes() [32]byte {
+	var b [32]byte
+	copy(b[:], limbsToBytes((*[4]uint64)(&s.s)))
+	return b
+}
+
+func (s *Scalar) Big() *big.Int {
+	return new(big.Int).SetBytes(limbsToBytes((*[4]uint64)(&s.s)))
+}
+
+func (s *Scalar) Add(u *Scalar) Scalar {
+	return Scalar{scalar(addMod((*[4]uint64)(&s.s), (*[4]uint64)(&u.s), (*[4]uint64)(&orderN)))}
+}
+
+func (s *Scalar) Sub(u *Scalar) Scalar {
+	return Scalar{scalar(subMod((*[4]uint64)(&s.s), (*[4]uint64)(&u.s), (*[4]uint64)(&orderN)))}
+}
+
+func (s *Scalar) Neg() Scalar {
+	var zero Scalar
+	return zero.Sub(s)
+}
+
+// Mul returns s*u mod N with two Montgomery multiplications.
+func (s *Scalar) Mul(u *Scalar) Scalar {
+	r := montMul(&s.s, &u.s)
+	return Scalar{montMul(&r, &orderR2)}
+}
+
+// Inverse returns s^-1 mod N computed as s^(N-2) with a fixed sequence of
+// Montgomery multiplications, the inverse of zero is zero.
+func (s *Scalar) Inverse() Scalar {
+	a := montMul(&s.s, &orderR2)
+
+	e := orderN
+	e[0] -= 2
+	one := scalar{1, 0, 0, 0}
+	r := montMul(&one, &orderR2)
+	for i := 255; i >= 0; i-- {
+		r = montMul(&r, &r)
+		if (e[i/64]>>(uint(i)%64))&1 == 1 {
+			r = montMul(&r, &a)
+		}
+	}
+	return Scalar{montMul(&r, &one)}
+}
+
+// IsZero only reveals whether s is zero, not which limbs are set.
+func (s *Scalar) IsZero() bool {
+	return s.s[0]|s.s[1]|s.s[2]|s.s[3] == 0
+}
+
+// Equal compares s and u without an early exit.
+func (s *Scalar) Equal(u *Scalar) bool {
+	d := s.Sub(u)
+	return d.IsZero()
+}
@@ -0,0 +1,12 @@
+package keys
+
+import (
+	"errors"
+)
+
+var (
+	// ErrInvalidKey is returned when a key is not usable, e.g. its point is not on the curve.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrInvalidSignature is returned when a signature does not verify.
+	ErrInvalidSignature = errors.New("invalid signature")
+)
@@ -0,0 +1,27 @@
+package keys
+
+import (
+	"testing"
+)
+
+func FuzzPublicKeyFromBytes(f *testing.F) {
+	priv, _ := GenerateKey()
+	f.Add(priv.PublicKey.Bytes(true))
+	f.Add(priv.PublicKey.Bytes(false))
+	f.Add([]byte{})
+	f.Add([]byte{0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pub, err := NewPublicKeyFromBytes(data)
+		if err != nil {
+			return
+		}
+		if pub.Point.IsIdentity() {
+			t.Fatal("decoded public key is the identity")
+		}
+		again, err := NewPublicKeyFromBytes(pub.Bytes(true))
+		if err != nil || !again.Point.IsEqual(pub.Point) {
+			t.Fatal("public key round trip mismatch")
+		}
+	})
+}
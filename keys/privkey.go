@@ -1,10 +1,9 @@
 package keys
 
 import (
-	"crypto/elliptic"
-	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"math/big"
 
 	"github.com/hongyuefan/prencrypt/curvebn"
@@ -18,44 +17,39 @@ type PrivateKey struct {
 }
 
 func GenerateKey() (*PrivateKey, error) {
-	p, x, y, err := elliptic.GenerateKey(util.Curve, rand.Reader)
+	return GenerateKeyWithRand(nil)
+}
+
+// GenerateKeyWithRand generates a key pair reading randomness from random,
+// nil means crypto/rand.
+func GenerateKeyWithRand(random io.Reader) (*PrivateKey, error) {
+	k, err := curvebn.RandomScalarWithRand(random)
 	if err != nil {
 		return nil, fmt.Errorf("cannot generate key pair: %v", err)
 	}
-	return &PrivateKey{
-		PublicKey: &PublicKey{
-			Point: &point.Point{
-				Curve: util.Curve,
-				X:     x,
-				Y:     y,
-			},
-		},
-		Bnkey: &curvebn.CurveBN{
-			Curve: util.Curve,
-			P:     p,
-		},
-	}, nil
+	return NewPrivateKeyFromBytes(k.Bytes()), nil
 }
 
 func NewPrivateKeyFromHex(s string) (*PrivateKey, error) {
 	b, err := hex.DecodeString(s)
 	if err != nil {
-		return nil, fmt.Errorf("cannot decode hex string: %v", err)
+		return nil, fmt.Errorf("%w: cannot decode hex string: %v", util.ErrMalformedEncoding, err)
+	}
+	k, err := curvebn.ScalarFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key: %w", err)
+	}
+	if k.IsZero() {
+		return nil, fmt.Errorf("%w: cannot parse private key: zero scalar", util.ErrMalformedEncoding)
 	}
 	return NewPrivateKeyFromBytes(b), nil
 }
 
 // NewPrivateKeyFromBytes decodes private key raw bytes, computes public key and returns PrivateKey instance
 func NewPrivateKeyFromBytes(priv []byte) *PrivateKey {
-	x, y := util.Curve.ScalarBaseMult(priv)
-
 	return &PrivateKey{
 		PublicKey: &PublicKey{
-			Point: &point.Point{
-				Curve: util.Curve,
-				X:     x,
-				Y:     y,
-			},
+			Point: point.BaseMulBytes(priv),
 		},
 		Bnkey: &curvebn.CurveBN{
 			Curve: util.Curve,
@@ -78,10 +72,17 @@ func (k *PrivateKey) Int() *big.Int {
 	return new(big.Int).SetBytes(k.Bnkey.P)
 }
 
+// Scalar returns the private key as a Scalar.
+func (k *PrivateKey) Scalar() *curvebn.Scalar {
+	return curvebn.ReduceScalar(k.Bnkey.P)
+}
+
+// Mul returns k*u mod N.
 func (k *PrivateKey) Mul(u *big.Int) *big.Int {
-	return new(big.Int).Mul(k.Int(), u)
+	return k.Scalar().Mul(curvebn.NewScalar(u)).Int()
 }
 
+// Add returns k+u mod N.
 func (k *PrivateKey) Add(u *big.Int) *big.Int {
-	return new(big.Int).Add(k.Int(), u)
+	return k.Scalar().Add(curvebn.NewScalar(u)).Int()
 }
@@ -0,0 +1,61 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+const SignatureLen = 2 * curvebn.ScalarLen
+
+// Sign produces a Schnorr signature e || s over msg, where R = k*G,
+// e = H(R || pub || msg) and s = k + e*priv mod N.
+func (k *PrivateKey) Sign(msg []byte) ([]byte, error) {
+	return k.SignWithRand(nil, msg)
+}
+
+// SignWithRand is Sign drawing the nonce from random, nil means crypto/rand.
+func (k *PrivateKey) SignWithRand(random io.Reader, msg []byte) ([]byte, error) {
+	nonce, err := GenerateKeyWithRand(random)
+	if err != nil {
+		return nil, err
+	}
+	e := signatureChallenge(nonce.PublicKey, k.PublicKey, msg)
+	s := e.Mul(k.Scalar()).Add(nonce.Scalar())
+	return util.AppendByt(e.Bytes(), s.Bytes()), nil
+}
+
+// Verify checks a signature produced by PrivateKey.Sign.
+func (k *PublicKey) Verify(msg, sig []byte) error {
+	if err := k.Validate(); err != nil {
+		return err
+	}
+	if len(sig) != SignatureLen {
+		return fmt.Errorf("%w: signature length error", util.ErrMalformedEncoding)
+	}
+	e, err := curvebn.ScalarFromBytes(sig[:curvebn.ScalarLen])
+	if err != nil {
+		return err
+	}
+	s, err := curvebn.ScalarFromBytes(sig[curvebn.ScalarLen:])
+	if err != nil {
+		return err
+	}
+
+	// R = s*G - e*pub
+	R := point.BaseMul(s.Int()).Add(k.Point.Mul(e.Neg().Int()))
+	if !signatureChallenge(&PublicKey{Point: R}, k, msg).IsEqual(e) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func signatureChallenge(R, pub *PublicKey, msg []byte) *curvebn.Scalar {
+	hash := sha256.Sum256(util.AppendByt(R.Bytes(true), pub.Bytes(true), msg))
+	return curvebn.NewScalar(new(big.Int).SetBytes(hash[:]))
+}
@@ -0,0 +1,324 @@
+package kfrag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+const (
+	// CommitmentLen is the length of KFrag.Commitment.
+	CommitmentLen = sha256.Size
+	// MaxThreshold is the largest threshold, and number of kfrags, Rkgen accepts.
+	MaxThreshold = 0xffff
+)
+
+var (
+	commitmentDomain = []byte("prencrypt policy commitment")
+	rootKeyDomain    = []byte("prencrypt policy root key")
+)
+
+// PolicyCommitment is what Alice publishes about a kfrag set: the keys it was
+// issued for, XA and, for every gate of its policy, the gate's threshold and
+// Feldman commitments. Alice signs it, and every kfrag and cfrag of the set
+// carries its Digest as Commitment, so Bob takes the thresholds and polynomials
+// from Alice rather than from the proxies. A flat t-of-N set has a single gate,
+// the root.
+//
+// RootKey is f(0)*G for the root polynomial f, and KeyChallenge, KeyResponse prove
+// that it has the same discrete log as the root gate's Secret. As f(0) = a/d,
+// d*RootKey = AlicePub, which lets a delegatee prove what it did with d, see
+// prencrypt.Forward.
+type PolicyCommitment struct {
+	AlicePub, BobPub *keys.PublicKey
+	XA, RootKey      *point.Point
+	Gates            []*GateCommitment
+
+	KeyChallenge, KeyResponse *curvebn.Scalar
+	Signature                 []byte
+}
+
+// GateCommitment is one gate of a PolicyCommitment: its path from the root, how
+// many shares it needs and the Feldman commitments Coefficients[j] = fn_j*U to the
+// coefficients of the gate's polynomial f, one per share needed. Every share
+// f(x)*U of the gate is sum x^j * Coefficients[j], see VerifyShare. Gates are
+// listed root first and every gate after its parent.
+type GateCommitment struct {
+	Path         []PathStep
+	Threshold    int
+	Coefficients []*point.Point
+}
+
+// Secret returns f(0)*U, the commitment to the gate's secret.
+func (g *GateCommitment) Secret() *point.Point {
+	return g.Coefficients[0]
+}
+
+// body encodes pc without its signature as
+//
+//	alicePub || bobPub || XA || rootKey || gates(2) ||
+//	    (steps(1) || path || threshold(2) || coefficients)...
+//
+// The root key proof is not part of it, so the digest does not depend on the
+// proof's randomness.
+func (pc *PolicyCommitment) body() []byte {
+	byt := util.AppendByt(pc.AlicePub.Bytes(true), pc.BobPub.Bytes(true), pc.XA.Marshal(), pc.RootKey.Marshal(), marshalThreshold(len(pc.Gates)))
+	for _, gate := range pc.Gates {
+		byt = append(byt, byte(len(gate.Path)))
+		byt = append(byt, MarshalPath(gate.Path)...)
+		byt = append(byt, marshalThreshold(gate.Threshold)...)
+		for _, c := range gate.Coefficients {
+			byt = append(byt, c.Marshal()...)
+		}
+	}
+	return byt
+}
+
+// Digest returns SHA256(domain || body), the value Alice signs and kfrags carry
+// as Commitment.
+func (pc *PolicyCommitment) Digest() []byte {
+	h := sha256.New()
+	h.Write(commitmentDomain)
+	h.Write(pc.body())
+	return h.Sum(nil)
+}
+
+// sign proves the root key with f0, the root polynomial's constant, and signs pc.
+func (pc *PolicyCommitment) sign(random io.Reader, privAlice *keys.PrivateKey, f0 *curvebn.Scalar) error {
+	k, err := curvebn.RandomScalarWithRand(random)
+	if err != nil {
+		return err
+	}
+	pc.KeyChallenge = pc.rootKeyChallenge(point.BaseMulBytes(k.Bytes()), point.UMulBytes(k.Bytes()))
+	pc.KeyResponse = k.Add(pc.KeyChallenge.Mul(f0))
+
+	sig, err := privAlice.SignWithRand(random, pc.Digest())
+	if err != nil {
+		return err
+	}
+	pc.Signature = sig
+	return nil
+}
+
+// Validate checks that all fields of pc are set, its points are valid and its
+// gates form a tree. It does not check the signature, see Verify.
+func (pc *PolicyCommitment) Validate() error {
+	if pc == nil || len(pc.Gates) == 0 || len(pc.Gates) > MaxThreshold || pc.KeyChallenge == nil || pc.KeyResponse == nil {
+		return fmt.Errorf("%w: policy commitment is incomplete", ErrInvalidKFrag)
+	}
+	if err := pc.AlicePub.Validate(); err != nil {
+		return err
+	}
+	if err := pc.BobPub.Validate(); err != nil {
+		return err
+	}
+	if err := point.ValidatePoints(pc.XA, pc.RootKey); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKFrag, err)
+	}
+	seen := make(map[string]bool, len(pc.Gates))
+	for i, gate := range pc.Gates {
+		if gate == nil || gate.Threshold < 1 || gate.Threshold > MaxThreshold {
+			return fmt.Errorf("%w: policy gate %d out of range", ErrInvalidKFrag, i)
+		}
+		if len(gate.Coefficients) != gate.Threshold {
+			return fmt.Errorf("%w: policy gate %d has %d coefficients for threshold %d", ErrInvalidKFrag, i, len(gate.Coefficients), gate.Threshold)
+		}
+		if err := ValidatePath(gate.Path); err != nil {
+			return err
+		}
+		if err := point.ValidatePoints(gate.Coefficients...); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidKFrag, err)
+		}
+		if (i == 0) != (len(gate.Path) == 0) {
+			return fmt.Errorf("%w: policy gates do not start at the root", ErrInvalidKFrag)
+		}
+		if i > 0 && !seen[string(MarshalPath(gate.Path[:len(gate.Path)-1]))] {
+			return fmt.Errorf("%w: policy gate %d comes before its parent", ErrInvalidKFrag, i)
+		}
+		key := string(MarshalPath(gate.Path))
+		if seen[key] {
+			return fmt.Errorf("%w: policy gate %d is repeated", ErrInvalidKFrag, i)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Verify checks that pc was signed by Alice for a delegation from alicePub to
+// bobPub and that its root key matches the root's secret.
+func (pc *PolicyCommitment) Verify(alicePub, bobPub *keys.PublicKey) error {
+	if err := pc.Validate(); err != nil {
+		return err
+	}
+	if err := alicePub.Validate(); err != nil {
+		return err
+	}
+	if err := bobPub.Validate(); err != nil {
+		return err
+	}
+	if !pc.AlicePub.Point.IsEqual(alicePub.Point) || !pc.BobPub.Point.IsEqual(bobPub.Point) {
+		return fmt.Errorf("%w: policy commitment is for other keys", ErrInvalidKFrag)
+	}
+	if err := alicePub.Verify(pc.Digest(), pc.Signature); err != nil {
+		return fmt.Errorf("policy commitment: %w", err)
+	}
+
+	// k*G = z*G - c*RootKey, k*U = z*U - c*Secret
+	z, c := pc.KeyResponse.Int(), pc.KeyChallenge.Int()
+	r1 := point.BaseMul(z).Sub(pc.RootKey.Mul(c))
+	r2 := point.UMul(z).Sub(pc.Gates[0].Secret().Mul(c))
+	if !pc.rootKeyChallenge(r1, r2).IsEqual(pc.KeyChallenge) {
+		return fmt.Errorf("%w: policy commitment root key proof", keys.ErrInvalidSignature)
+	}
+	return nil
+}
+
+func (pc *PolicyCommitment) rootKeyChallenge(r1, r2 *point.Point) *curvebn.Scalar {
+	return curvebn.HashToScalar(rootKeyDomain, pc.Digest(), r1.Marshal(), r2.Marshal())
+}
+
+// Gate returns the gate at path, or nil if pc has none.
+func (pc *PolicyCommitment) Gate(path []PathStep) *GateCommitment {
+	key := MarshalPath(path)
+	for _, gate := range pc.Gates {
+		if bytes.Equal(MarshalPath(gate.Path), key) {
+			return gate
+		}
+	}
+	return nil
+}
+
+// Marshal encodes pc as body || key challenge || key response || signature.
+func (pc *PolicyCommitment) Marshal() []byte {
+	return util.AppendByt(pc.body(), pc.KeyChallenge.Bytes(), pc.KeyResponse.Bytes(), pc.Signature)
+}
+
+// Unmarshal decodes the encoding produced by Marshal, pc is only modified if the
+// whole input is valid. It does not check the signature, see Verify.
+func (pc *PolicyCommitment) Unmarshal(data []byte) error {
+	pLen := point.NewPoint().Len()
+	if len(data) < 4*pLen+2 {
+		return fmt.Errorf("%w: policy commitment data length error", util.ErrMalformedEncoding)
+	}
+	alicePub, err := keys.NewPublicKeyFromBytes(data[:pLen])
+	if err != nil {
+		return err
+	}
+	bobPub, err := keys.NewPublicKeyFromBytes(data[pLen : 2*pLen])
+	if err != nil {
+		return err
+	}
+	xa, rootKey := point.NewPoint(), point.NewPoint()
+	if err := xa.Unmarshal(data[2*pLen : 3*pLen]); err != nil {
+		return err
+	}
+	if err := rootKey.Unmarshal(data[3*pLen : 4*pLen]); err != nil {
+		return err
+	}
+	gates := make([]*GateCommitment, binary.BigEndian.Uint16(data[4*pLen:]))
+	data = data[4*pLen+2:]
+	for i := range gates {
+		if len(data) < 1 || len(data) < 1+2*int(data[0])+2 {
+			return fmt.Errorf("%w: policy commitment data length error", util.ErrMalformedEncoding)
+		}
+		pathLen := 2 * int(data[0])
+		path, err := UnmarshalPath(data[1 : 1+pathLen])
+		if err != nil {
+			return err
+		}
+		data = data[1+pathLen:]
+		threshold := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if len(data) < threshold*pLen {
+			return fmt.Errorf("%w: policy commitment data length error", util.ErrMalformedEncoding)
+		}
+		coefficients := make([]*point.Point, threshold)
+		for j := range coefficients {
+			coefficients[j] = point.NewPoint()
+			if err := coefficients[j].Unmarshal(data[j*pLen : (j+1)*pLen]); err != nil {
+				return err
+			}
+		}
+		gates[i] = &GateCommitment{Path: path, Threshold: threshold, Coefficients: coefficients}
+		data = data[threshold*pLen:]
+	}
+	if len(data) != 2*curvebn.ScalarLen+keys.SignatureLen {
+		return fmt.Errorf("%w: policy commitment data length error", util.ErrMalformedEncoding)
+	}
+	keyChallenge, err := curvebn.ScalarFromBytes(data[:curvebn.ScalarLen])
+	if err != nil {
+		return err
+	}
+	keyResponse, err := curvebn.ScalarFromBytes(data[curvebn.ScalarLen : 2*curvebn.ScalarLen])
+	if err != nil {
+		return err
+	}
+
+	decoded := &PolicyCommitment{
+		AlicePub:     alicePub,
+		BobPub:       bobPub,
+		XA:           xa,
+		RootKey:      rootKey,
+		Gates:        gates,
+		KeyChallenge: keyChallenge,
+		KeyResponse:  keyResponse,
+		Signature:    append([]byte(nil), data[2*curvebn.ScalarLen:]...),
+	}
+	if err := decoded.Validate(); err != nil {
+		return err
+	}
+	*pc = *decoded
+	return nil
+}
+
+func (pc *PolicyCommitment) Hex() string {
+	return hex.EncodeToString(pc.Marshal())
+}
+
+func (pc *PolicyCommitment) FromHex(s string) error {
+	data, err := util.HexToBytes(s)
+	if err != nil {
+		return err
+	}
+	return pc.Unmarshal(data)
+}
+
+// VerifyShare checks a share of a gate against the gate's Feldman commitments: u
+// must be f(x)*U for the committed polynomial f, that is sum x^j * coefficients[j].
+func VerifyShare(coefficients []*point.Point, x *curvebn.Scalar, u *point.Point) error {
+	if len(coefficients) == 0 || len(coefficients) > MaxThreshold {
+		return fmt.Errorf("%w: commitment count out of range", ErrInvalidKFrag)
+	}
+	if x == nil || u == nil {
+		return fmt.Errorf("%w: share is incomplete", ErrInvalidKFrag)
+	}
+	powers := make([]*big.Int, len(coefficients))
+	xj := curvebn.NewScalar(big.NewInt(1))
+	for j := range coefficients {
+		powers[j] = xj.Int()
+		xj = xj.Mul(x)
+	}
+	expected, err := point.MultiMul(coefficients, powers)
+	if err != nil {
+		return err
+	}
+	if !expected.IsEqual(u) {
+		return fmt.Errorf("%w: share is not on the committed polynomial", ErrInvalidKFrag)
+	}
+	return nil
+}
+
+func marshalThreshold(t int) []byte {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(t))
+	return b[:]
+}
@@ -0,0 +1,162 @@
+package kfrag
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/symcrypt"
+	"github.com/hongyuefan/prencrypt/util"
+	"golang.org/x/crypto/hkdf"
+)
+
+// DelegationVersion is the version byte of the sealed delegation state.
+const DelegationVersion = 1
+
+var (
+	delegationInfo = []byte("prencrypt delegation state key")
+	delegationAD   = []byte("prencrypt delegation state")
+)
+
+// Delegation is the secret state behind a set of kfrags: the polynomial fn and
+// the ephemeral point XA. Keep it only sealed, anyone holding it can issue kfrags.
+type Delegation struct {
+	alicePub, bobPub *keys.PublicKey
+	xa               *point.Point
+	fn               []*curvebn.Scalar
+}
+
+func newDelegation(alicePub, bobPub *keys.PublicKey, xa *point.Point, fn []*curvebn.Scalar) *Delegation {
+	return &Delegation{alicePub: alicePub, bobPub: bobPub, xa: xa, fn: fn}
+}
+
+func (dl *Delegation) Threshold() int {
+	return len(dl.fn)
+}
+
+func (dl *Delegation) BobPublicKey() *keys.PublicKey {
+	return dl.bobPub
+}
+
+// Commitment returns the commitment carried by the delegation's kfrags, the
+// digest of its PolicyCommitment.
+func (dl *Delegation) Commitment() []byte {
+	return dl.policyCommitment().Digest()
+}
+
+// PolicyCommitment returns the delegation's policy commitment signed by Alice.
+// Bob needs it to open the cfrags of the delegation's kfrags, and it stays the
+// same for kfrags issued later, only the signature is drawn anew.
+func (dl *Delegation) PolicyCommitment(random io.Reader, privAlice *keys.PrivateKey) (*PolicyCommitment, error) {
+	if privAlice == nil || !privAlice.PublicKey.Point.IsEqual(dl.alicePub.Point) {
+		return nil, fmt.Errorf("%w: private key does not match the delegation", keys.ErrInvalidKey)
+	}
+	pc := dl.policyCommitment()
+	if err := pc.sign(random, privAlice, dl.fn[0]); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// policyCommitment returns the unsigned commitment of a flat delegation.
+func (dl *Delegation) policyCommitment() *PolicyCommitment {
+	return &PolicyCommitment{
+		AlicePub: dl.alicePub,
+		BobPub:   dl.bobPub,
+		XA:       dl.xa,
+		RootKey:  point.BaseMulBytes(dl.fn[0].Bytes()),
+		Gates:    []*GateCommitment{dl.gateCommitment(nil)},
+	}
+}
+
+// gateCommitment returns the commitment of the gate at path whose polynomial is dl.fn.
+func (dl *Delegation) gateCommitment(path []PathStep) *GateCommitment {
+	coefficients := make([]*point.Point, len(dl.fn))
+	for j, c := range dl.fn {
+		coefficients[j] = point.UMulBytes(c.Bytes())
+	}
+	return &GateCommitment{Path: path, Threshold: len(dl.fn), Coefficients: coefficients}
+}
+
+// Seal encrypts the delegation under a key derived from Alice's private key, as
+//
+//	version(1) || alicePub || bobPub || XA || t(2) || fn_0 || ... || fn_{t-1}
+//
+// sealed with symcrypt.EncryptAesWithRand.
+func (dl *Delegation) Seal(random io.Reader, privAlice *keys.PrivateKey) ([]byte, error) {
+	key, err := delegationKey(privAlice)
+	if err != nil {
+		return nil, err
+	}
+	state := []byte{DelegationVersion}
+	state = append(state, dl.alicePub.Bytes(true)...)
+	state = append(state, dl.bobPub.Bytes(true)...)
+	state = append(state, dl.xa.Marshal()...)
+	state = append(state, marshalThreshold(len(dl.fn))...)
+	for _, c := range dl.fn {
+		state = append(state, c.Bytes()...)
+	}
+	return symcrypt.EncryptAesWithRand(random, key, state, delegationAD)
+}
+
+// OpenDelegation decrypts a delegation sealed by Seal with the same private key.
+func OpenDelegation(privAlice *keys.PrivateKey, sealed []byte) (*Delegation, error) {
+	key, err := delegationKey(privAlice)
+	if err != nil {
+		return nil, err
+	}
+	state, err := symcrypt.DecryptAesWithAD(key, sealed, delegationAD)
+	if err != nil {
+		return nil, err
+	}
+
+	pLen := point.NewPoint().Len()
+	header := 1 + 3*pLen + 2
+	if len(state) < header || state[0] != DelegationVersion {
+		return nil, fmt.Errorf("%w: delegation state header error", util.ErrMalformedEncoding)
+	}
+	alicePub, err := keys.NewPublicKeyFromBytes(state[1 : 1+pLen])
+	if err != nil {
+		return nil, err
+	}
+	if !alicePub.Point.IsEqual(privAlice.PublicKey.Point) {
+		return nil, fmt.Errorf("%w: delegation state belongs to another key", util.ErrMalformedEncoding)
+	}
+	bobPub, err := keys.NewPublicKeyFromBytes(state[1+pLen : 1+2*pLen])
+	if err != nil {
+		return nil, err
+	}
+	xa := point.NewPoint()
+	if err := xa.Unmarshal(state[1+2*pLen : 1+3*pLen]); err != nil {
+		return nil, err
+	}
+	if err := xa.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", util.ErrMalformedEncoding, err)
+	}
+	t := int(state[1+3*pLen])<<8 | int(state[2+3*pLen])
+	if t < 1 || len(state) != header+t*curvebn.ScalarLen {
+		return nil, fmt.Errorf("%w: delegation state length error", util.ErrMalformedEncoding)
+	}
+	fn := make([]*curvebn.Scalar, t)
+	for i := range fn {
+		off := header + i*curvebn.ScalarLen
+		if fn[i], err = curvebn.ScalarFromBytes(state[off : off+curvebn.ScalarLen]); err != nil {
+			return nil, err
+		}
+	}
+	return newDelegation(alicePub, bobPub, xa, fn), nil
+}
+
+func delegationKey(privAlice *keys.PrivateKey) ([]byte, error) {
+	if privAlice == nil {
+		return nil, fmt.Errorf("%w: private key is nil", keys.ErrInvalidKey)
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, privAlice.Scalar().Bytes(), nil, delegationInfo), key); err != nil {
+		return nil, fmt.Errorf("cannot derive delegation key: %v", err)
+	}
+	return key, nil
+}
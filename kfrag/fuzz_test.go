@@ -0,0 +1,33 @@
+package kfrag
+
+import (
+	"testing"
+
+	"github.com/hongyuefan/prencrypt/keys"
+)
+
+func FuzzKFragUnmarshal(f *testing.F) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	kFrags, _, _ := Rkgen(privAlice, privBob.PublicKey, 2, 2)
+	for _, kf := range kFrags {
+		f.Add(kf.Marshal())
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0xff})
+	f.Add([]byte{32, 1, 2, 3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		kf := NewKFrag()
+		if err := kf.Unmarshal(data); err != nil {
+			return
+		}
+		again := NewKFrag()
+		if err := again.Unmarshal(kf.Marshal()); err != nil {
+			t.Fatalf("re-encoded kfrag does not decode: %v", err)
+		}
+		if again.Hex() != kf.Hex() {
+			t.Fatal("kfrag round trip mismatch")
+		}
+	})
+}
@@ -1,29 +1,55 @@
 package kfrag
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
 
 	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
 	"github.com/hongyuefan/prencrypt/point"
 	"github.com/hongyuefan/prencrypt/util"
 )
 
+// ErrInvalidKFrag is returned for a kfrag that is incomplete or degenerate.
+var ErrInvalidKFrag = errors.New("invalid kfrag")
+
+// MaxEpoch is the number of refresh rounds a kfrag can go through.
+const MaxEpoch = math.MaxUint32
+
+// KFrag is one share of a re-encryption key. Commitment is the digest of the
+// PolicyCommitment of the kfrag's set and Path locates the gate the kfrag belongs
+// to in its policy tree, it is empty for a plain t-of-N delegation, see
+// RkgenPolicy. Share is the share index the gate's polynomial was evaluated at, so
+// U = Rk*U can be checked against the gate's Feldman commitments, see VerifyShare;
+// it is public, so the proxies can refresh their kfrags among themselves. Z1 and
+// Z2 are Alice's signature on the kfrag, see Verify. Epoch counts the refresh
+// rounds the kfrag went through; Coefficients are the gate's commitments after
+// them and are nil at epoch 0, where the policy commitment's apply.
 type KFrag struct {
-	Id, Rk, Z1 *curvebn.CurveBN
+	Id, Rk, Z1 *curvebn.Scalar
 	U, XA      *point.Point
-	Z2         *big.Int
+	Z2         *curvebn.Scalar
+
+	Commitment   []byte
+	Share        *curvebn.Scalar
+	Path         []PathStep
+	Epoch        uint32
+	Coefficients []*point.Point
 }
 
 func NewKFrag() *KFrag {
 	return &KFrag{
-		Id: curvebn.NewCurveBN(nil),
-		Rk: curvebn.NewCurveBN(nil),
-		Z1: curvebn.NewCurveBN(nil),
+		Id: curvebn.NewScalar(big.NewInt(0)),
+		Rk: curvebn.NewScalar(big.NewInt(0)),
+		Z1: curvebn.NewScalar(big.NewInt(0)),
 		U:  point.NewPoint(),
 		XA: point.NewPoint(),
-		Z2: big.NewInt(0),
+		Z2: curvebn.NewScalar(big.NewInt(0)),
 	}
 }
 
@@ -47,44 +73,200 @@ func (kf *KFrag) Marshal() []byte {
 	byt = append(byt, byte(len(kf.Z2.Bytes())))
 	byt = append(byt, kf.Z2.Bytes()...)
 
+	byt = append(byt, byte(len(kf.Commitment)))
+	byt = append(byt, kf.Commitment...)
+
+	byt = append(byt, byte(len(kf.Share.Bytes())))
+	byt = append(byt, kf.Share.Bytes()...)
+
+	path := MarshalPath(kf.Path)
+	byt = append(byt, byte(len(path)))
+	byt = append(byt, path...)
+
+	byt = append(byt, 4)
+	byt = binary.BigEndian.AppendUint32(byt, kf.Epoch)
+
+	byt = append(byt, 2)
+	byt = append(byt, marshalThreshold(len(kf.Coefficients))...)
+	for _, c := range kf.Coefficients {
+		byt = append(byt, byte(len(c.Marshal())))
+		byt = append(byt, c.Marshal()...)
+	}
+
 	return byt
 }
 
+// Unmarshal decodes the length prefixed encoding produced by Marshal. kf is only
+// modified if the whole input is valid.
 func (kf *KFrag) Unmarshal(data []byte) error {
+	var fields [11][]byte
+	for i := range fields {
+		field, rest, err := readField(data)
+		if err != nil {
+			return err
+		}
+		fields[i], data = field, rest
+	}
+	path, err := UnmarshalPath(fields[8])
+	if err != nil {
+		return err
+	}
+	if len(fields[9]) != 4 || len(fields[10]) != 2 {
+		return fmt.Errorf("%w: kfrag data length error", util.ErrMalformedEncoding)
+	}
+	var coefficients []*point.Point
+	for i := 0; i < int(binary.BigEndian.Uint16(fields[10])); i++ {
+		field, rest, err := readField(data)
+		if err != nil {
+			return err
+		}
+		c := point.NewPoint()
+		if err := c.Unmarshal(field); err != nil {
+			return err
+		}
+		coefficients, data = append(coefficients, c), rest
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%w: kfrag data length error", util.ErrMalformedEncoding)
+	}
 
-	idLen := int(data[:1][0])
-	idByt := data[1:idLen+1]
-	kf.Id = curvebn.NewCurveBN(idByt)
+	var scalars [5]*curvebn.Scalar
+	for i, field := range [][]byte{fields[0], fields[1], fields[2], fields[5], fields[7]} {
+		s, err := curvebn.ScalarFromBytes(field)
+		if err != nil {
+			return err
+		}
+		scalars[i] = s
+	}
+	u, xa := point.NewPoint(), point.NewPoint()
+	if err := u.Unmarshal(fields[3]); err != nil {
+		return err
+	}
+	if err := xa.Unmarshal(fields[4]); err != nil {
+		return err
+	}
 
-	rkLen := int(data[1+idLen : 2+idLen][0])
-	rkByt := data[2+idLen : 2+idLen+rkLen]
-	kf.Rk = curvebn.NewCurveBN(rkByt)
+	decoded := &KFrag{
+		Id: scalars[0], Rk: scalars[1], Z1: scalars[2], Z2: scalars[3], U: u, XA: xa,
+		Commitment:   append([]byte(nil), fields[6]...),
+		Share:        scalars[4],
+		Path:         path,
+		Epoch:        binary.BigEndian.Uint32(fields[9]),
+		Coefficients: coefficients,
+	}
+	if err := decoded.Validate(); err != nil {
+		return err
+	}
+	*kf = *decoded
+	return nil
+}
 
-	z1Len := int(data[2+idLen+rkLen : 3+idLen+rkLen][0])
-	z1Byt := data[3+idLen+rkLen : 3+idLen+rkLen+z1Len]
-	kf.Z1 = curvebn.NewCurveBN(z1Byt)
+// Validate checks that all fields of kf are set, its points are valid and Id, Rk
+// and Share are not zero. It does not check the signature or the share, see Verify.
+func (kf *KFrag) Validate() error {
+	if kf == nil || kf.Id == nil || kf.Rk == nil || kf.Z1 == nil || kf.Z2 == nil || kf.Share == nil {
+		return fmt.Errorf("%w: kfrag is incomplete", ErrInvalidKFrag)
+	}
+	if kf.Id.IsZero() || kf.Rk.IsZero() || kf.Share.IsZero() {
+		return fmt.Errorf("%w: kfrag has a zero scalar", ErrInvalidKFrag)
+	}
+	if len(kf.Commitment) != CommitmentLen {
+		return fmt.Errorf("%w: kfrag commitment length error", ErrInvalidKFrag)
+	}
+	if err := point.ValidatePoints(kf.U, kf.XA); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKFrag, err)
+	}
+	if err := ValidatePath(kf.Path); err != nil {
+		return err
+	}
+	if (kf.Epoch == 0) != (len(kf.Coefficients) == 0) || len(kf.Coefficients) > MaxThreshold {
+		return fmt.Errorf("%w: only refreshed kfrags carry coefficients", ErrInvalidKFrag)
+	}
+	if err := point.ValidatePoints(kf.Coefficients...); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKFrag, err)
+	}
+	return nil
+}
 
-	uLen := int(data[3+idLen+rkLen+z1Len : 4+idLen+rkLen+z1Len][0])
-	uByt := data[4+idLen+rkLen+z1Len : 4+idLen+rkLen+z1Len+uLen]
-	u := point.NewPoint()
-	if err := u.Unmarshal(uByt); err != nil {
+// Verify checks that kf was issued by Alice to Bob as part of the set pc commits
+// to: pc must be signed by alicePub for bobPub, name kf's gate, Z1, Z2 must be
+// Alice's signature on kf's Id, share index, commitment and path, and kf's share
+// must lie on its gate's polynomial, see VerifyShare. A proxy runs it before
+// accepting a kfrag. The signature does not cover Rk or U, so a refreshed kfrag
+// verifies too, against the coefficients of its refresh round.
+func (kf *KFrag) Verify(alicePub, bobPub *keys.PublicKey, pc *PolicyCommitment) error {
+	if err := kf.Validate(); err != nil {
+		return err
+	}
+	if err := pc.Verify(alicePub, bobPub); err != nil {
+		return err
+	}
+	if err := kf.VerifyShare(pc); err != nil {
 		return err
 	}
-	kf.U = u
 
-	xaLen := int(data[4+idLen+rkLen+z1Len+uLen : 5+idLen+rkLen+z1Len+uLen][0])
-	xaByt := data[5+idLen+rkLen+z1Len+uLen : 5+idLen+rkLen+z1Len+uLen+xaLen]
-	xa := point.NewPoint()
-	if err := xa.Unmarshal(xaByt); err != nil {
+	// Y = z2*G + z1*A, as z2 = y - a*z1
+	y := point.BaseMulBytes(kf.Z2.Bytes()).Add(alicePub.Point.MulBytes(kf.Z1.Bytes()))
+	if !signatureChallenge(&keys.PublicKey{Point: y}, kf.Id, kf.Share, alicePub, bobPub, kf.XA, kf.Commitment, kf.Path).IsEqual(kf.Z1) {
+		return fmt.Errorf("%w: kfrag signature", keys.ErrInvalidSignature)
+	}
+	return nil
+}
+
+// VerifyShare checks that kf belongs to the set pc commits to and that U = Rk*U
+// lies on its gate's polynomial, so a proxy can tell that its kfrag is consistent
+// with the other proxies'. It does not check pc's signature, see Verify.
+func (kf *KFrag) VerifyShare(pc *PolicyCommitment) error {
+	if err := kf.Validate(); err != nil {
+		return err
+	}
+	gate, err := kf.gate(pc)
+	if err != nil {
+		return err
+	}
+	coefficients, err := GateCoefficients(gate, kf.Coefficients)
+	if err != nil {
 		return err
 	}
-	kf.XA = xa
+	if !kf.U.IsEqual(point.UMulBytes(kf.Rk.Bytes())) {
+		return fmt.Errorf("%w: kfrag U is not its Rk", ErrInvalidKFrag)
+	}
+	return VerifyShare(coefficients, kf.Share, kf.U)
+}
 
-	z2Len := int(data[5+idLen+rkLen+z1Len+uLen+xaLen : 6+idLen+rkLen+z1Len+uLen+xaLen][0])
-	z2Byt := data[6+idLen+rkLen+z1Len+uLen+xaLen : 6+idLen+rkLen+z1Len+uLen+xaLen+z2Len]
-	kf.Z2 = new(big.Int).SetBytes(z2Byt)
+// gate returns kf's gate in pc.
+func (kf *KFrag) gate(pc *PolicyCommitment) (*GateCommitment, error) {
+	if err := pc.Validate(); err != nil {
+		return nil, err
+	}
+	gate := pc.Gate(kf.Path)
+	if !bytes.Equal(kf.Commitment, pc.Digest()) || !kf.XA.IsEqual(pc.XA) || gate == nil {
+		return nil, fmt.Errorf("%w: kfrag does not belong to the policy commitment", ErrInvalidKFrag)
+	}
+	return gate, nil
+}
 
-	return nil
+// GateCoefficients returns the Feldman commitments a share of gate is checked
+// against: refreshed, the coefficients carried by a refreshed kfrag or cfrag, or
+// the gate's own if refreshed is nil. A refresh keeps the gate's secret, so
+// refreshed must commit to the same one.
+func GateCoefficients(gate *GateCommitment, refreshed []*point.Point) ([]*point.Point, error) {
+	if len(refreshed) == 0 {
+		return gate.Coefficients, nil
+	}
+	if len(refreshed) != gate.Threshold || !refreshed[0].IsEqual(gate.Secret()) {
+		return nil, fmt.Errorf("%w: refreshed coefficients do not match the gate", ErrInvalidKFrag)
+	}
+	return refreshed, nil
+}
+
+// readField splits a one byte length prefixed field off data.
+func readField(data []byte) ([]byte, []byte, error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, nil, fmt.Errorf("%w: kfrag data length error", util.ErrMalformedEncoding)
+	}
+	l := int(data[0])
+	return data[1 : 1+l], data[1+l:], nil
 }
 
 func (kf *KFrag) Hex() string {
@@ -103,5 +285,5 @@ func (kf *KFrag) String() string {
 	if kf.Rk == nil || kf.Z1 == nil || kf.Z2 == nil || kf.U == nil || kf.XA == nil {
 		return ""
 	}
-	return fmt.Sprintf("Id:%v,Rk:%v,Z1:%v,Z2:%v,U:%v,XA:%v", kf.Id, kf.Rk.String(), kf.Z1.String(), kf.Z2.String(), kf.U.Marshal(), kf.XA.Marshal())
+	return fmt.Sprintf("Id:%v,Rk:%v,Z1:%v,Z2:%v,U:%v,XA:%v,Share:%v,Epoch:%v", kf.Id, kf.Rk.String(), kf.Z1.String(), kf.Z2.String(), kf.U.Marshal(), kf.XA.Marshal(), kf.Share, kf.Epoch)
 }
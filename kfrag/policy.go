@@ -0,0 +1,229 @@
+package kfrag
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// MaxPathDepth is the deepest gate of a policy tree.
+const MaxPathDepth = 63
+
+var gateIndexDomain = []byte("prencrypt policy gate index")
+
+// Policy is a node of a threshold tree. A gate needs Threshold of its children,
+// where a leaf counts Weight times and a sub gate once; a leaf is a proxy that
+// receives Weight kfrags of its gate. Build policies with Leaf, Gate, And and Or,
+// e.g. And(Gate(2, legal...), Gate(3, infra...)).
+type Policy struct {
+	Threshold int
+	Children  []*Policy
+	Weight    int
+}
+
+// Leaf returns a proxy holding weight shares of its gate.
+func Leaf(weight int) *Policy {
+	return &Policy{Weight: weight}
+}
+
+// Gate returns a node satisfied by threshold of its children.
+func Gate(threshold int, children ...*Policy) *Policy {
+	return &Policy{Threshold: threshold, Children: children}
+}
+
+// And returns a gate that needs all its children.
+func And(children ...*Policy) *Policy {
+	total := 0
+	for _, c := range children {
+		total += c.count()
+	}
+	return Gate(total, children...)
+}
+
+// Or returns a gate that needs any one of its children.
+func Or(children ...*Policy) *Policy {
+	return Gate(1, children...)
+}
+
+func (p *Policy) isLeaf() bool {
+	return len(p.Children) == 0
+}
+
+// count is how many shares of its parent gate p takes.
+func (p *Policy) count() int {
+	if p.isLeaf() {
+		return p.Weight
+	}
+	return 1
+}
+
+// Leaves returns the leaves of p in the order RkgenPolicy issues their kfrags.
+func (p *Policy) Leaves() []*Policy {
+	if p.isLeaf() {
+		return []*Policy{p}
+	}
+	var leaves []*Policy
+	for _, c := range p.Children {
+		leaves = append(leaves, c.Leaves()...)
+	}
+	return leaves
+}
+
+func (p *Policy) validate(depth int) error {
+	if p == nil {
+		return fmt.Errorf("%w: policy is nil", ErrInvalidKFrag)
+	}
+	if depth > MaxPathDepth {
+		return fmt.Errorf("%w: policy is too deep", ErrInvalidKFrag)
+	}
+	if p.isLeaf() {
+		if p.Weight < 1 || p.Weight > MaxThreshold {
+			return fmt.Errorf("%w: policy leaf weight out of range", ErrInvalidKFrag)
+		}
+		return nil
+	}
+	total := 0
+	for _, c := range p.Children {
+		if err := c.validate(depth + 1); err != nil {
+			return err
+		}
+		total += c.count()
+	}
+	if total > MaxThreshold || p.Threshold < 1 || p.Threshold > total {
+		return fmt.Errorf("%w: policy threshold out of range", ErrInvalidKFrag)
+	}
+	return nil
+}
+
+// PathStep is one gate on the way from the root of a policy to a kfrag: the
+// position of the child taken. Paths carry no thresholds, those come from the
+// PolicyCommitment Alice signs, so a proxy cannot change a gate's threshold by
+// rewriting the path of its cfrags.
+type PathStep struct {
+	Position int
+}
+
+// MarshalPath encodes path as position(2) per step.
+func MarshalPath(path []PathStep) []byte {
+	byt := make([]byte, 0, 2*len(path))
+	for _, step := range path {
+		byt = append(byt, marshalThreshold(step.Position)...)
+	}
+	return byt
+}
+
+func UnmarshalPath(data []byte) ([]PathStep, error) {
+	if len(data)%2 != 0 || len(data)/2 > MaxPathDepth {
+		return nil, fmt.Errorf("%w: path length error", util.ErrMalformedEncoding)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	path := make([]PathStep, len(data)/2)
+	for i := range path {
+		path[i].Position = int(binary.BigEndian.Uint16(data[2*i:]))
+	}
+	return path, ValidatePath(path)
+}
+
+func ValidatePath(path []PathStep) error {
+	if len(path) > MaxPathDepth {
+		return fmt.Errorf("%w: path is too deep", ErrInvalidKFrag)
+	}
+	for _, step := range path {
+		if step.Position < 0 || step.Position > MaxThreshold {
+			return fmt.Errorf("%w: path step out of range", ErrInvalidKFrag)
+		}
+	}
+	return nil
+}
+
+// GateIndex returns the share index of the sub gate reached by path in its parent
+// gate, H(D, path) under its own domain. Like ShareIndex it needs D, which only
+// Alice and Bob can compute.
+func GateIndex(D *curvebn.Scalar, path []PathStep) *curvebn.Scalar {
+	return curvebn.HashToScalar(gateIndexDomain, D.Bytes(), MarshalPath(path))
+}
+
+func RkgenPolicy(privAlice *keys.PrivateKey, bobPub *keys.PublicKey, policy *Policy) ([][]*KFrag, *PolicyCommitment, error) {
+	return RkgenPolicyWithRand(nil, privAlice, bobPub, policy)
+}
+
+// RkgenPolicyWithRand is RkgenWithRand for a policy tree. Every gate gets its own
+// polynomial whose constant is the parent's polynomial at the gate's GateIndex,
+// the root's constant being the delegated secret. It returns the kfrags of each
+// leaf in the order of policy.Leaves(), and the policy commitment with every gate
+// in the order the gates are visited.
+func RkgenPolicyWithRand(random io.Reader, privAlice *keys.PrivateKey, bobPub *keys.PublicKey, policy *Policy) ([][]*KFrag, *PolicyCommitment, error) {
+	if err := policy.validate(0); err != nil {
+		return nil, nil, err
+	}
+	if policy.isLeaf() {
+		policy = Gate(policy.Weight, policy)
+	}
+	root, err := NewDelegation(random, privAlice, bobPub, policy.Threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	D := shareIndexKey(privAlice, bobPub)
+	pc := root.policyCommitment()
+	leaves, err := root.planPolicy(random, D, policy, nil, pc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := pc.sign(random, privAlice, root.fn[0]); err != nil {
+		return nil, nil, err
+	}
+
+	commitment := pc.Digest()
+	kfrags := make([][]*KFrag, len(leaves))
+	for i, leaf := range leaves {
+		if kfrags[i], err = leaf.dl.generate(random, privAlice, D, commitment, leaf.path, leaf.weight); err != nil {
+			return nil, nil, err
+		}
+	}
+	return kfrags, pc, nil
+}
+
+// policyLeaf is a leaf of a policy being issued and the polynomial of its gate.
+type policyLeaf struct {
+	dl     *Delegation
+	path   []PathStep
+	weight int
+}
+
+// planPolicy draws the polynomials of the sub gates of gate, which is at path and
+// has the polynomial dl.fn, adds their commitments to pc and returns the leaves
+// below gate in order.
+func (dl *Delegation) planPolicy(random io.Reader, D *curvebn.Scalar, gate *Policy, path []PathStep, pc *PolicyCommitment) ([]policyLeaf, error) {
+	var leaves []policyLeaf
+	for position, child := range gate.Children {
+		if child.isLeaf() {
+			leaves = append(leaves, policyLeaf{dl: dl, path: path, weight: child.Weight})
+			continue
+		}
+
+		childPath := append(append([]PathStep(nil), path...), PathStep{Position: position})
+		fn := make([]*curvebn.Scalar, child.Threshold)
+		fn[0] = evaluatePolynomial(dl.fn, GateIndex(D, childPath))
+		for i := 1; i < len(fn); i++ {
+			c, err := curvebn.RandomScalarWithRand(random)
+			if err != nil {
+				return nil, err
+			}
+			fn[i] = c
+		}
+		sub := newDelegation(dl.alicePub, dl.bobPub, dl.xa, fn)
+		pc.Gates = append(pc.Gates, sub.gateCommitment(childPath))
+		subLeaves, err := sub.planPolicy(random, D, child, childPath, pc)
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, subLeaves...)
+	}
+	return leaves, nil
+}
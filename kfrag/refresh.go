@@ -0,0 +1,197 @@
+package kfrag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+// A refresh round lets the proxies holding the kfrags of one gate re-randomize
+// their Rk shares without Alice: every proxy deals a random polynomial delta with
+// delta(0) = 0 and sends delta(s) to the proxy with share index s, which adds the
+// sum of what it received to Rk. The share indices are public in the kfrags, so
+// the proxies need nobody else for the round. f(0) = a/d and so Bob's ability to
+// decrypt are unchanged, while shares from before the refresh no longer combine
+// with shares from after it.
+//
+// Public share indices mean that t colluding proxies can interpolate a/d from
+// their Rk, refreshed or not; without Bob's key they cannot remove d.
+//
+// All proxies of the gate must take part and apply the same dealings, otherwise
+// their shares no longer lie on one polynomial and Bob rejects them. Only gates
+// without sub gates can be refreshed, as the secrets of sub gates are shares of
+// their parent's original polynomial. Kfrags issued later from a Delegation
+// belong to that original polynomial too.
+//
+// Alice's signature Z1, Z2 does not cover Rk or U, so a refreshed kfrag keeps it
+// and still verifies: Refresh adds the dealers' commitments to its coefficients,
+// which commit to the same secret as the gate's, and bumps Epoch.
+
+// RefreshShare is what one dealer sends one proxy: the Feldman commitments
+// delta_k*U for k = 1..t-1, the same for every recipient, and delta(s), which
+// must only be sent to the proxy whose share index is s. Adding every dealer's
+// commitments to the gate's coefficients gives the refreshed ones, see Refresh.
+type RefreshShare struct {
+	Commitments []*point.Point
+	Value       *curvebn.Scalar
+}
+
+// NewRefreshDealing draws a zero polynomial of the gate's degree and returns the
+// share for each of shares, the share index of every proxy taking part, in order.
+func NewRefreshDealing(random io.Reader, threshold int, shares []*curvebn.Scalar) ([]*RefreshShare, error) {
+	if threshold < 1 || threshold > MaxThreshold {
+		return nil, fmt.Errorf("%w: refresh threshold out of range", ErrInvalidKFrag)
+	}
+	delta := make([]*curvebn.Scalar, threshold)
+	delta[0] = curvebn.NewScalar(big.NewInt(0))
+	commitments := make([]*point.Point, threshold-1)
+	for k := 1; k < threshold; k++ {
+		c, err := curvebn.RandomScalarWithRand(random)
+		if err != nil {
+			return nil, err
+		}
+		delta[k] = c
+		commitments[k-1] = point.UMulBytes(c.Bytes())
+	}
+
+	dealing := make([]*RefreshShare, len(shares))
+	for i, s := range shares {
+		dealing[i] = &RefreshShare{Commitments: commitments, Value: evaluatePolynomial(delta, s)}
+	}
+	return dealing, nil
+}
+
+// Refresh checks every received share against its dealer's commitments and returns
+// kf moved onto the refreshed polynomial. pc is the policy commitment of kf's set,
+// which gives the gate's threshold. kf itself is not modified.
+func (kf *KFrag) Refresh(pc *PolicyCommitment, received []*RefreshShare) (*KFrag, error) {
+	if err := kf.Validate(); err != nil {
+		return nil, err
+	}
+	gate, err := kf.refreshGate(pc)
+	if err != nil {
+		return nil, err
+	}
+	coefficients, err := GateCoefficients(gate, kf.Coefficients)
+	if err != nil {
+		return nil, err
+	}
+	if kf.Epoch == MaxEpoch {
+		return nil, fmt.Errorf("%w: kfrag epoch out of range", ErrInvalidKFrag)
+	}
+
+	rk := kf.Rk
+	coefficients = append([]*point.Point(nil), coefficients...)
+	for _, r := range received {
+		if err := r.verify(gate.Threshold, kf.Share); err != nil {
+			return nil, err
+		}
+		rk = rk.Add(r.Value)
+		for k, c := range r.Commitments {
+			coefficients[k+1] = coefficients[k+1].Add(c)
+		}
+	}
+	if rk.IsZero() {
+		return nil, fmt.Errorf("%w: refreshed kfrag has a zero scalar", ErrInvalidKFrag)
+	}
+
+	refreshed := *kf
+	refreshed.Rk = rk
+	refreshed.U = point.UMulBytes(rk.Bytes())
+	refreshed.Epoch++
+	refreshed.Coefficients = coefficients
+	return &refreshed, nil
+}
+
+// refreshGate returns kf's gate in pc, which must not have sub gates.
+func (kf *KFrag) refreshGate(pc *PolicyCommitment) (*GateCommitment, error) {
+	gate, err := kf.gate(pc)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range pc.Gates {
+		if len(g.Path) == len(gate.Path)+1 && bytes.Equal(MarshalPath(g.Path[:len(gate.Path)]), MarshalPath(gate.Path)) {
+			return nil, fmt.Errorf("%w: a gate with sub gates cannot be refreshed", ErrInvalidKFrag)
+		}
+	}
+	return gate, nil
+}
+
+var errRefreshShare = fmt.Errorf("%w: refresh share is not on the dealt polynomial", ErrInvalidKFrag)
+
+// verify checks Value*U == sum_{k>=1} share^k * Commitments[k-1].
+func (r *RefreshShare) verify(threshold int, share *curvebn.Scalar) error {
+	if r == nil || r.Value == nil || len(r.Commitments) != threshold-1 {
+		return fmt.Errorf("%w: refresh share is incomplete", ErrInvalidKFrag)
+	}
+	if threshold == 1 {
+		if !r.Value.IsZero() {
+			return errRefreshShare
+		}
+		return nil
+	}
+	if err := point.ValidatePoints(r.Commitments...); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKFrag, err)
+	}
+	powers := make([]*big.Int, len(r.Commitments))
+	xk := share
+	for k := range powers {
+		powers[k] = xk.Int()
+		xk = xk.Mul(share)
+	}
+	expected, err := point.MultiMul(r.Commitments, powers)
+	if err != nil {
+		return err
+	}
+	if !expected.IsEqual(point.UMulBytes(r.Value.Bytes())) {
+		return errRefreshShare
+	}
+	return nil
+}
+
+// RefreshAll runs a refresh round among kfrags in process, every kfrag acting as
+// one proxy, and returns the refreshed kfrags in the same order.
+func RefreshAll(random io.Reader, pc *PolicyCommitment, kfrags []*KFrag) ([]*KFrag, error) {
+	if len(kfrags) == 0 {
+		return nil, fmt.Errorf("%w: a refresh needs every kfrag", ErrInvalidKFrag)
+	}
+	shares := make([]*curvebn.Scalar, len(kfrags))
+	for i, kf := range kfrags {
+		if err := kf.Validate(); err != nil {
+			return nil, err
+		}
+		if kf.Epoch != kfrags[0].Epoch || !kf.XA.IsEqual(kfrags[0].XA) || !bytes.Equal(kf.Commitment, kfrags[0].Commitment) || !bytes.Equal(MarshalPath(kf.Path), MarshalPath(kfrags[0].Path)) {
+			return nil, fmt.Errorf("%w: kfrags come from different gates or refresh rounds", ErrInvalidKFrag)
+		}
+		shares[i] = kf.Share
+	}
+	gate, err := kfrags[0].refreshGate(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	received := make([][]*RefreshShare, len(kfrags))
+	for range kfrags {
+		dealing, err := NewRefreshDealing(random, gate.Threshold, shares)
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range dealing {
+			received[i] = append(received[i], r)
+		}
+	}
+
+	refreshed := make([]*KFrag, len(kfrags))
+	for i, kf := range kfrags {
+		r, err := kf.Refresh(pc, received[i])
+		if err != nil {
+			return nil, err
+		}
+		refreshed[i] = r
+	}
+	return refreshed, nil
+}
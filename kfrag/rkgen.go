@@ -1,89 +1,181 @@
 package kfrag
 
 import (
-	"errors"
-	"math/big"
+	"fmt"
+	"io"
 
 	"github.com/hongyuefan/prencrypt/curvebn"
 	"github.com/hongyuefan/prencrypt/keys"
 	"github.com/hongyuefan/prencrypt/point"
-	"github.com/hongyuefan/prencrypt/util"
 )
 
-func Rkgen(privAlice *keys.PrivateKey, bobPub *keys.PublicKey, N, t int) ([]*KFrag, error) {
+// Rkgen issues N kfrags of which Bob needs t, and the policy commitment Bob
+// opens their cfrags with.
+func Rkgen(privAlice *keys.PrivateKey, bobPub *keys.PublicKey, N, t int) ([]*KFrag, *PolicyCommitment, error) {
+	return RkgenWithRand(nil, privAlice, bobPub, N, t)
+}
+
+// RkgenWithRand is Rkgen reading randomness from random, nil means crypto/rand.
+func RkgenWithRand(random io.Reader, privAlice *keys.PrivateKey, bobPub *keys.PublicKey, N, t int) ([]*KFrag, *PolicyCommitment, error) {
 
 	if t > N {
-		return nil, errors.New("t can not bigger than N")
+		return nil, nil, fmt.Errorf("%w: t can not bigger than N", ErrInvalidKFrag)
 	}
-	if privAlice == nil || bobPub == nil {
-		return nil, errors.New("params can not be nil")
+	if N > MaxThreshold {
+		return nil, nil, fmt.Errorf("%w: t or N out of range", ErrInvalidKFrag)
 	}
-
-	privX, err := keys.GenerateKey()
+	dl, err := NewDelegation(random, privAlice, bobPub, t)
 	if err != nil {
+		return nil, nil, err
+	}
+	pc, err := dl.PolicyCommitment(random, privAlice)
+	if err != nil {
+		return nil, nil, err
+	}
+	kfrags, err := dl.Generate(random, privAlice, N)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kfrags, pc, nil
+}
+
+// NewDelegation draws the polynomial of a t-of-N delegation from Alice to Bob.
+// Kfrags are issued from it with Generate, and it can be kept with Seal to issue
+// more kfrags for new proxies later.
+func NewDelegation(random io.Reader, privAlice *keys.PrivateKey, bobPub *keys.PublicKey, t int) (*Delegation, error) {
+	if t < 1 || t > MaxThreshold {
+		return nil, fmt.Errorf("%w: t or N out of range", ErrInvalidKFrag)
+	}
+	if privAlice == nil {
+		return nil, fmt.Errorf("%w: private key is nil", keys.ErrInvalidKey)
+	}
+	if err := bobPub.Validate(); err != nil {
 		return nil, err
 	}
 
-	d, err := curvebn.PointsHash2CurvBN(privX.PublicKey.Point, bobPub.Point, bobPub.Point.Mul(privX.Int()))
+	privX, err := keys.GenerateKeyWithRand(random)
 	if err != nil {
 		return nil, err
 	}
 
-	fn := make([]*big.Int, t)
+	d := PrecursorKey(privX.PublicKey.Point, bobPub.Point, bobPub.Point.MulBytes(privX.Scalar().Bytes()))
+
+	fn := make([]*curvebn.Scalar, t)
 
-	fn[0] = new(big.Int).Mul(privAlice.Int(), d.Convert2CanInverseCurvBN().InverseModCurvBN())
+	fn[0] = privAlice.Scalar().Mul(d.Inverse())
 
 	for i := 1; i < t; i++ {
-		rands, err := keys.GenerateKey()
+		rands, err := curvebn.RandomScalarWithRand(random)
 		if err != nil {
 			return nil, err
 		}
-		fn[i] = rands.Int()
+		fn[i] = rands
 	}
 
-	D, err := curvebn.PointsHash2CurvBN(privAlice.PublicKey.Point, bobPub.Point, bobPub.Point.Mul(privAlice.Int()))
-	if err != nil {
-		return nil, err
+	return newDelegation(privAlice.PublicKey, bobPub, privX.PublicKey.Point, fn), nil
+}
+
+// Generate issues n more kfrags on the delegation's polynomial. They are
+// interchangeable with every kfrag issued before, so existing proxies keep working.
+func (dl *Delegation) Generate(random io.Reader, privAlice *keys.PrivateKey, n int) ([]*KFrag, error) {
+	if n < 0 || n > MaxThreshold {
+		return nil, fmt.Errorf("%w: t or N out of range", ErrInvalidKFrag)
+	}
+	if privAlice == nil || !privAlice.PublicKey.Point.IsEqual(dl.alicePub.Point) {
+		return nil, fmt.Errorf("%w: private key does not match the delegation", keys.ErrInvalidKey)
 	}
+	return dl.generate(random, privAlice, shareIndexKey(privAlice, dl.bobPub), dl.Commitment(), nil, n)
+}
+
+var (
+	precursorDomain     = []byte("prencrypt precursor key")
+	shareIndexKeyDomain = []byte("prencrypt share index key")
+	shareIndexDomain    = []byte("prencrypt share index")
+	signatureDomain     = []byte("prencrypt kfrag signature")
+)
 
-	kfrags := make([]*KFrag, N)
+// PrecursorKey returns d = H(XA, pubB, shared) with shared = x*pubB = b*XA, the
+// scalar that fn(0) = a/d is blinded with.
+func PrecursorKey(xa, bobPub, shared *point.Point) *curvebn.Scalar {
+	return curvebn.HashPointsToScalar(precursorDomain, xa, bobPub, shared)
+}
 
-	for i := 0; i < N; i++ {
+// ShareIndexKey returns D = H(pubA, pubB, shared) with shared = a*pubB = b*pubA.
+func ShareIndexKey(alicePub, bobPub, shared *point.Point) *curvebn.Scalar {
+	return curvebn.HashPointsToScalar(shareIndexKeyDomain, alicePub, bobPub, shared)
+}
 
-		privY, err := keys.GenerateKey()
-		if err != nil {
-			return nil, err
-		}
+// ShareIndex returns the share index H(id, D) of the kfrag with the given Id.
+func ShareIndex(id, D *curvebn.Scalar) *curvebn.Scalar {
+	return curvebn.HashToScalar(shareIndexDomain, id.Bytes(), D.Bytes())
+}
+
+// shareIndexKey returns D = H(pubA, pubB, a*pubB). Only Alice and Bob can compute
+// it, so a proxy cannot pick its share index, but the indices themselves are
+// public, see KFrag.Share.
+func shareIndexKey(privAlice *keys.PrivateKey, bobPub *keys.PublicKey) *curvebn.Scalar {
+	return ShareIndexKey(privAlice.PublicKey.Point, bobPub.Point, bobPub.Point.MulBytes(privAlice.Scalar().Bytes()))
+}
+
+// generate issues n kfrags at random share indices of dl.fn, for the gate at path
+// of the policy whose digest is commitment.
+func (dl *Delegation) generate(random io.Reader, privAlice *keys.PrivateKey, D *curvebn.Scalar, commitment []byte, path []PathStep, n int) ([]*KFrag, error) {
+	bobPub := dl.bobPub
+
+	kfrags := make([]*KFrag, n)
+
+	for i := 0; i < n; i++ {
 
-		privID, err := keys.GenerateKey()
+		privY, err := keys.GenerateKeyWithRand(random)
 		if err != nil {
 			return nil, err
 		}
-		s, err := curvebn.BytesHash2CurvBN(util.AppendByt(privID.Bytes(), D.P))
+
+		privID, err := keys.GenerateKeyWithRand(random)
 		if err != nil {
 			return nil, err
 		}
+		s := ShareIndex(privID.Scalar(), D)
 
-		rk := util.EvaluatePolynomial(fn, s.Int())
+		rk := evaluatePolynomial(dl.fn, s)
 
-		u := point.UPoint().Mul(rk)
+		u := point.UMulBytes(rk.Bytes())
 
-		z1, err := curvebn.BytesHash2CurvBN(util.AppendByt(privY.PublicKey.Bytes(true), privID.Bytes(), privAlice.PublicKey.Bytes(true), bobPub.Bytes(true), u.Marshal(), privX.PublicKey.Bytes(true)))
-		if err != nil {
-			return nil, err
-		}
+		z1 := signatureChallenge(privY.PublicKey, privID.Scalar(), s, privAlice.PublicKey, bobPub, dl.xa, commitment, path)
 
-		z2 := new(big.Int).Sub(privY.Int(), new(big.Int).Mul(privAlice.Int(), z1.Int()))
+		z2 := privY.Scalar().Sub(privAlice.Scalar().Mul(z1))
 
 		kfrags[i] = &KFrag{
-			Id: privID.Bnkey,
-			Rk: curvebn.NewCurveBN(rk.Bytes()),
-			XA: privX.PublicKey.Point,
+			Id: privID.Scalar(),
+			Rk: rk,
+			XA: dl.xa,
 			Z1: z1,
 			U:  u,
 			Z2: z2,
+
+			Commitment: commitment,
+			Share:      s,
+			Path:       path,
 		}
 	}
 
 	return kfrags, nil
 }
+
+// signatureChallenge returns z1 of Alice's signature on a kfrag, which covers the
+// policy commitment and the kfrag's path along with its share index. U is not
+// signed, it is bound to the commitment by the gate's coefficients instead, so a
+// refreshed kfrag keeps a valid signature.
+func signatureChallenge(y *keys.PublicKey, id, share *curvebn.Scalar, alicePub, bobPub *keys.PublicKey, xa *point.Point, commitment []byte, path []PathStep) *curvebn.Scalar {
+	return curvebn.HashToScalar(signatureDomain, y.Bytes(true), id.Bytes(), share.Bytes(), alicePub.Bytes(true), bobPub.Bytes(true), xa.Marshal(), commitment, MarshalPath(path))
+}
+
+// evaluatePolynomial returns fn(x) by Horner's rule in Scalar arithmetic, as the
+// coefficients are secret.
+func evaluatePolynomial(fn []*curvebn.Scalar, x *curvebn.Scalar) *curvebn.Scalar {
+	result := fn[len(fn)-1]
+	for i := len(fn) - 2; i >= 0; i-- {
+		result = result.Mul(x).Add(fn[i])
+	}
+	return result
+}
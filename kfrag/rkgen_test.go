@@ -1,9 +1,11 @@
 package kfrag
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -12,7 +14,7 @@ func TestRkgen(t *testing.T) {
 	privAlice, _ := keys.GenerateKey()
 	privBob, _ := keys.GenerateKey()
 
-	kFrags, err := Rkgen(privAlice, privBob.PublicKey, 1, 1)
+	kFrags, _, err := Rkgen(privAlice, privBob.PublicKey, 1, 1)
 	if !assert.NoError(t, err) {
 		return
 	}
@@ -22,3 +24,69 @@ func TestRkgen(t *testing.T) {
 		assert.Equal(t, tKfrag.Hex(), kfrag.Hex())
 	}
 }
+
+func TestVerify(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+
+	kFrags, pc, err := Rkgen(privAlice, privBob.PublicKey, 4, 3)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, pc.Verify(privAlice.PublicKey, privBob.PublicKey))
+	for _, kf := range kFrags {
+		assert.NoError(t, kf.Verify(privAlice.PublicKey, privBob.PublicKey, pc))
+		decoded := NewKFrag()
+		assert.NoError(t, decoded.FromHex(kf.Hex()))
+		assert.NoError(t, decoded.Verify(privAlice.PublicKey, privBob.PublicKey, pc))
+	}
+
+	// the gate's coefficients bind Rk through U, Alice's signature the share index
+	other, otherPC, _ := Rkgen(privAlice, privBob.PublicKey, 4, 3)
+	forged := *kFrags[0]
+	forged.Rk, forged.U = other[0].Rk, other[0].U
+	assert.True(t, errors.Is(forged.VerifyShare(pc), ErrInvalidKFrag))
+	assert.True(t, errors.Is(forged.Verify(privAlice.PublicKey, privBob.PublicKey, pc), ErrInvalidKFrag))
+	forged = *kFrags[0]
+	forged.Share = kFrags[1].Share
+	assert.True(t, errors.Is(forged.Verify(privAlice.PublicKey, privBob.PublicKey, pc), ErrInvalidKFrag))
+	forged = *kFrags[0]
+	forged.Id = kFrags[1].Id
+	assert.True(t, errors.Is(forged.Verify(privAlice.PublicKey, privBob.PublicKey, pc), keys.ErrInvalidSignature))
+	assert.True(t, errors.Is(kFrags[0].Verify(privAlice.PublicKey, privBob.PublicKey, otherPC), ErrInvalidKFrag))
+	assert.Error(t, kFrags[0].Verify(privBob.PublicKey, privAlice.PublicKey, pc))
+}
+
+func TestPolicyCommitment(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+
+	_, pc, err := RkgenPolicy(privAlice, privBob.PublicKey, And(Gate(2, Leaf(1), Leaf(1), Leaf(1)), Leaf(2)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, pc.Gates, 2)
+	decoded := &PolicyCommitment{}
+	if !assert.NoError(t, decoded.FromHex(pc.Hex())) {
+		return
+	}
+	assert.Equal(t, pc.Hex(), decoded.Hex())
+	assert.Equal(t, pc.Digest(), decoded.Digest())
+	assert.NoError(t, decoded.Verify(privAlice.PublicKey, privBob.PublicKey))
+	assert.True(t, errors.Is(decoded.Verify(privBob.PublicKey, privAlice.PublicKey), ErrInvalidKFrag))
+
+	// the signature covers the thresholds
+	decoded.Gates[1].Threshold = 1
+	decoded.Gates[1].Coefficients = pc.Gates[1].Coefficients[:1]
+	assert.True(t, errors.Is(decoded.Verify(privAlice.PublicKey, privBob.PublicKey), keys.ErrInvalidSignature))
+
+	// the root key proof is checked apart from the signature
+	decoded.Gates[1].Threshold = pc.Gates[1].Threshold
+	decoded.Gates[1].Coefficients = pc.Gates[1].Coefficients
+	assert.NoError(t, decoded.Verify(privAlice.PublicKey, privBob.PublicKey))
+	decoded.KeyResponse = decoded.KeyResponse.Add(decoded.KeyChallenge)
+	assert.True(t, errors.Is(decoded.Verify(privAlice.PublicKey, privBob.PublicKey), keys.ErrInvalidSignature))
+
+	data := pc.Marshal()
+	assert.True(t, errors.Is(decoded.Unmarshal(data[:len(data)-1]), util.ErrMalformedEncoding))
+}
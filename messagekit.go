@@ -0,0 +1,361 @@
+package prencrypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+const (
+	MessageKitVersion = 1
+
+	// AlgAesGcm is AES-256-GCM as implemented by symcrypt.EncryptAesWithAD.
+	AlgAesGcm = 1
+)
+
+// MessageKit bundles a capsule with the ciphertext it protects, so both travel and
+// are stored together. SenderKey and Signature are optional and carry the identity
+// of the encryptor, Label is an optional policy label bound into the ciphertext.
+type MessageKit struct {
+	Version    uint8
+	Capsule    *capsule.Capsule
+	Algorithm  uint8
+	Ciphertext []byte
+	SenderKey  *keys.PublicKey
+	Signature  []byte
+	Label      []byte
+}
+
+func NewMessageKit() *MessageKit {
+	return &MessageKit{
+		Version:   MessageKitVersion,
+		Capsule:   capsule.NewCapsule(),
+		Algorithm: AlgAesGcm,
+	}
+}
+
+// Encrypt encrypts plainText for alicePub and stores the capsule and ciphertext in the kit.
+func (mk *MessageKit) Encrypt(alicePub *keys.PublicKey, plainText []byte) error {
+	return mk.EncryptWithRand(nil, alicePub, plainText)
+}
+
+// EncryptWithRand is Encrypt reading randomness from random, nil means crypto/rand.
+func (mk *MessageKit) EncryptWithRand(random io.Reader, alicePub *keys.PublicKey, plainText []byte) error {
+	if err := checkLenPrefixed(mk.Label, 2); err != nil {
+		return err
+	}
+	// nonce (16) + tag (16) must still fit the 4 byte ciphertext prefix
+	if uint64(len(plainText))+32 > 0xffffffff {
+		return fmt.Errorf("%w: message kit plaintext of %d bytes is too long", ErrInvalidArgument, len(plainText))
+	}
+	cipherText, capsl, err := EncryptWithRand(random, alicePub, plainText, mk.Label)
+	if err != nil {
+		return err
+	}
+	mk.Version = MessageKitVersion
+	mk.Algorithm = AlgAesGcm
+	mk.Capsule = capsl
+	mk.Ciphertext = cipherText
+	return nil
+}
+
+// Decrypt opens the kit with Alice's private key.
+func (mk *MessageKit) Decrypt(alicePriv *keys.PrivateKey) ([]byte, error) {
+	if err := mk.check(); err != nil {
+		return nil, err
+	}
+	return DecryptOriginal(alicePriv, mk.Capsule, mk.Ciphertext, mk.Label)
+}
+
+// DecryptReencrypted opens the kit with Bob's private key and the cfrags of its
+// capsule, pc being the policy commitment of their kfrags.
+func (mk *MessageKit) DecryptReencrypted(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, cfrags []*cfrag.CFrag) ([]byte, error) {
+	if err := mk.check(); err != nil {
+		return nil, err
+	}
+	return DecryptReencrypted(privBob, pubAlice, pc, mk.Capsule, cfrags, mk.Ciphertext, mk.Label)
+}
+
+func (mk *MessageKit) check() error {
+	if mk.Version != MessageKitVersion {
+		return fmt.Errorf("%w: unsupported message kit version %d", ErrMalformedEncoding, mk.Version)
+	}
+	if mk.Algorithm != AlgAesGcm {
+		return fmt.Errorf("%w: unsupported message kit algorithm %d", ErrMalformedEncoding, mk.Algorithm)
+	}
+	if mk.Capsule == nil {
+		return fmt.Errorf("%w: message kit has no capsule", ErrMalformedEncoding)
+	}
+	return nil
+}
+
+// Marshal encodes the kit as
+// version(1) || algorithm(1) || len(2) capsule || len(4) ciphertext || len(1) senderKey || len(2) signature || len(2) label
+// A field too long for its length prefix is an ErrInvalidArgument.
+func (mk *MessageKit) Marshal() ([]byte, error) {
+	var senderKey []byte
+	if mk.SenderKey != nil {
+		senderKey = mk.SenderKey.Bytes(true)
+	}
+	var capByt []byte
+	if mk.Capsule != nil {
+		capByt = mk.Capsule.Marshal()
+	}
+
+	byt := []byte{mk.Version, mk.Algorithm}
+	for _, f := range []struct {
+		field []byte
+		size  int
+	}{{capByt, 2}, {mk.Ciphertext, 4}, {senderKey, 1}, {mk.Signature, 2}, {mk.Label, 2}} {
+		var err error
+		if byt, err = appendLenPrefixed(byt, f.field, f.size); err != nil {
+			return nil, err
+		}
+	}
+	return byt, nil
+}
+
+func (mk *MessageKit) Unmarshal(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("%w: message kit data length error", ErrMalformedEncoding)
+	}
+	version, algorithm := data[0], data[1]
+	data = data[2:]
+
+	var fields [5][]byte
+	for i, size := range []int{2, 4, 1, 2, 2} {
+		field, rest, err := readLenPrefixed(data, size)
+		if err != nil {
+			return err
+		}
+		fields[i], data = field, rest
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%w: message kit data length error", ErrMalformedEncoding)
+	}
+
+	capsl := capsule.NewCapsule()
+	if err := capsl.Unmarshal(fields[0]); err != nil {
+		return err
+	}
+	var senderKey *keys.PublicKey
+	if len(fields[2]) != 0 {
+		pub, err := keys.NewPublicKeyFromBytes(fields[2])
+		if err != nil {
+			return err
+		}
+		senderKey = pub
+	}
+
+	mk.Version = version
+	mk.Algorithm = algorithm
+	mk.Capsule = capsl
+	mk.Ciphertext = fields[1]
+	mk.SenderKey = senderKey
+	mk.Signature = fields[3]
+	mk.Label = fields[4]
+	return nil
+}
+
+func (mk *MessageKit) Hex() (string, error) {
+	byt, err := mk.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(byt), nil
+}
+
+func (mk *MessageKit) FromHex(s string) error {
+	byt, err := util.HexToBytes(s)
+	if err != nil {
+		return err
+	}
+	return mk.Unmarshal(byt)
+}
+
+type messageKitJSON struct {
+	Version    uint8  `json:"version"`
+	Capsule    string `json:"capsule"`
+	Algorithm  uint8  `json:"algorithm"`
+	Ciphertext string `json:"ciphertext"`
+	SenderKey  string `json:"sender_key,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+	Label      string `json:"label,omitempty"`
+}
+
+func (mk *MessageKit) MarshalJSON() ([]byte, error) {
+	j := messageKitJSON{
+		Version:    mk.Version,
+		Algorithm:  mk.Algorithm,
+		Ciphertext: hex.EncodeToString(mk.Ciphertext),
+		Signature:  hex.EncodeToString(mk.Signature),
+		Label:      hex.EncodeToString(mk.Label),
+	}
+	if mk.Capsule != nil {
+		j.Capsule = mk.Capsule.Hex()
+	}
+	if mk.SenderKey != nil {
+		j.SenderKey = mk.SenderKey.Hex(true)
+	}
+	return json.Marshal(&j)
+}
+
+func (mk *MessageKit) UnmarshalJSON(data []byte) error {
+	var j messageKitJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	capsl := capsule.NewCapsule()
+	if err := capsl.FromHex(j.Capsule); err != nil {
+		return err
+	}
+	var senderKey *keys.PublicKey
+	if j.SenderKey != "" {
+		pub, err := keys.NewPublicKeyFromHex(j.SenderKey)
+		if err != nil {
+			return err
+		}
+		senderKey = pub
+	}
+	var fields [3][]byte
+	for i, s := range []string{j.Ciphertext, j.Signature, j.Label} {
+		byt, err := util.HexToBytes(s)
+		if err != nil {
+			return err
+		}
+		if len(byt) != 0 {
+			fields[i] = byt
+		}
+	}
+
+	mk.Version = j.Version
+	mk.Algorithm = j.Algorithm
+	mk.Capsule = capsl
+	mk.Ciphertext = fields[0]
+	mk.SenderKey = senderKey
+	mk.Signature = fields[1]
+	mk.Label = fields[2]
+	return nil
+}
+
+func appendLenPrefixed(byt, field []byte, size int) ([]byte, error) {
+	if err := checkLenPrefixed(field, size); err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, uint32(len(field)))
+	byt = append(byt, prefix[4-size:]...)
+	return append(byt, field...), nil
+}
+
+// checkLenPrefixed rejects a field whose length does not fit a size byte prefix,
+// which appendLenPrefixed would otherwise silently truncate.
+func checkLenPrefixed(field []byte, size int) error {
+	if uint64(len(field)) >= uint64(1)<<(8*size) {
+		return fmt.Errorf("%w: field of %d bytes exceeds its %d byte length prefix", ErrInvalidArgument, len(field), size)
+	}
+	return nil
+}
+
+func readLenPrefixed(data []byte, size int) ([]byte, []byte, error) {
+	if len(data) < size {
+		return nil, nil, fmt.Errorf("%w: length prefixed field is truncated", ErrMalformedEncoding)
+	}
+	prefix := make([]byte, 4)
+	copy(prefix[4-size:], data[:size])
+	l := int(binary.BigEndian.Uint32(prefix))
+	data = data[size:]
+	if len(data) < l {
+		return nil, nil, fmt.Errorf("%w: length prefixed field is truncated", ErrMalformedEncoding)
+	}
+	if l == 0 {
+		return nil, data, nil
+	}
+	return data[:l], data[l:], nil
+}
+
+// EncryptSigned is Encrypt followed by Sign, so the recipient can check who produced the kit.
+func (mk *MessageKit) EncryptSigned(alicePub *keys.PublicKey, signer *keys.PrivateKey, plainText []byte) error {
+	return mk.EncryptSignedWithRand(nil, alicePub, signer, plainText)
+}
+
+// EncryptSignedWithRand is EncryptSigned reading randomness from random, nil means crypto/rand.
+func (mk *MessageKit) EncryptSignedWithRand(random io.Reader, alicePub *keys.PublicKey, signer *keys.PrivateKey, plainText []byte) error {
+	if err := mk.EncryptWithRand(random, alicePub, plainText); err != nil {
+		return err
+	}
+	return mk.SignWithRand(random, signer)
+}
+
+// Sign signs the capsule, the ciphertext hash and the label with the sender's key
+// and stores the sender's public key and signature in the kit.
+func (mk *MessageKit) Sign(signer *keys.PrivateKey) error {
+	return mk.SignWithRand(nil, signer)
+}
+
+// SignWithRand is Sign drawing the signature nonce from random, nil means crypto/rand.
+func (mk *MessageKit) SignWithRand(random io.Reader, signer *keys.PrivateKey) error {
+	if signer == nil {
+		return fmt.Errorf("%w: signer is nil", ErrInvalidKey)
+	}
+	if err := mk.check(); err != nil {
+		return err
+	}
+	sig, err := signer.SignWithRand(random, mk.signedDigest())
+	if err != nil {
+		return err
+	}
+	mk.SenderKey = signer.PublicKey
+	mk.Signature = sig
+	return nil
+}
+
+// Verify checks that the kit was signed by expectedSender.
+func (mk *MessageKit) Verify(expectedSender *keys.PublicKey) error {
+	if expectedSender == nil {
+		return fmt.Errorf("%w: expected sender is nil", ErrInvalidKey)
+	}
+	if err := mk.check(); err != nil {
+		return err
+	}
+	if mk.SenderKey == nil || len(mk.Signature) == 0 {
+		return fmt.Errorf("%w: message kit is not signed", ErrInvalidSignature)
+	}
+	if !bytes.Equal(mk.SenderKey.Bytes(true), expectedSender.Bytes(true)) {
+		return fmt.Errorf("%w: message kit signed by unexpected sender", ErrInvalidSignature)
+	}
+	return expectedSender.Verify(mk.signedDigest(), mk.Signature)
+}
+
+// DecryptFrom is Decrypt that only releases the plaintext if the kit was signed by expectedSender.
+func (mk *MessageKit) DecryptFrom(alicePriv *keys.PrivateKey, expectedSender *keys.PublicKey) ([]byte, error) {
+	if err := mk.Verify(expectedSender); err != nil {
+		return nil, err
+	}
+	return mk.Decrypt(alicePriv)
+}
+
+// DecryptReencryptedFrom is DecryptReencrypted that only releases the plaintext if the kit
+// was signed by expectedSender.
+func (mk *MessageKit) DecryptReencryptedFrom(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, cfrags []*cfrag.CFrag, expectedSender *keys.PublicKey) ([]byte, error) {
+	if err := mk.Verify(expectedSender); err != nil {
+		return nil, err
+	}
+	return mk.DecryptReencrypted(privBob, pubAlice, pc, cfrags)
+}
+
+func (mk *MessageKit) signedDigest() []byte {
+	cipherTextHash := sha256.Sum256(mk.Ciphertext)
+	return util.AppendByt([]byte("prencrypt message kit"), []byte{mk.Version, mk.Algorithm}, associatedData(mk.Capsule, mk.Label), cipherTextHash[:])
+}
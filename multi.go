@@ -0,0 +1,163 @@
+package prencrypt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/symcrypt"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+const (
+	// MultiKeyLen is the length of the shared key of a MultiCapsule.
+	MultiKeyLen = 32
+	// MaxRecipients is the largest number of recipients of a MultiCapsule.
+	MaxRecipients = 0xffff
+
+	// sealedKeyLen is the length of a sealed shared key, nonce(16) || tag(16) ||
+	// key as written by symcrypt.EncryptAesWithRand.
+	sealedKeyLen = 32 + MultiKeyLen
+)
+
+var multiKeyDomain = []byte("prencrypt multi-recipient key")
+
+// MultiCapsule encapsulates one shared key for several recipients. All of them
+// share Capsule, so every recipient opens it like a capsule of its own, with
+// DecapsulateOriginal or, after proxies re-encrypted it with the recipient's
+// kfrags, DecapsulateFrags. That gives a capsule key that differs per recipient,
+// and Keys[i] is the shared key sealed under the capsule key of the i-th recipient,
+// see SharedKey.
+type MultiCapsule struct {
+	Capsule *capsule.Capsule
+	Keys    [][]byte
+}
+
+func EncapsulateMulti(pubs []*keys.PublicKey) ([]byte, *MultiCapsule, error) {
+	return EncapsulateMultiWithRand(nil, pubs)
+}
+
+// EncapsulateMultiWithRand is EncapsulateMulti reading randomness from random, nil
+// means crypto/rand. r and u are drawn once for all recipients, which is safe as
+// long as their keys are distinct, so a repeated key is rejected.
+func EncapsulateMultiWithRand(random io.Reader, pubs []*keys.PublicKey) ([]byte, *MultiCapsule, error) {
+	if len(pubs) == 0 {
+		return nil, nil, fmt.Errorf("%w: no recipients", ErrInvalidKey)
+	}
+	if len(pubs) > MaxRecipients {
+		return nil, nil, fmt.Errorf("%w: more than %d recipients", ErrInvalidKey, MaxRecipients)
+	}
+	seen := make(map[string]bool, len(pubs))
+	for _, pub := range pubs {
+		if err := pub.Validate(); err != nil {
+			return nil, nil, err
+		}
+		id := string(pub.Bytes(true))
+		if seen[id] {
+			return nil, nil, fmt.Errorf("%w: recipient key is repeated", ErrInvalidKey)
+		}
+		seen[id] = true
+	}
+
+	capsl, ru, err := newCapsule(random)
+	if err != nil {
+		return nil, nil, err
+	}
+	sharedKey := make([]byte, MultiKeyLen)
+	if _, err := io.ReadFull(util.RandReader(random), sharedKey); err != nil {
+		return nil, nil, err
+	}
+
+	mc := &MultiCapsule{Capsule: capsl, Keys: make([][]byte, len(pubs))}
+	for i, pub := range pubs {
+		capsuleKey, err := pub.Point.MulBytes(ru.Bytes()).KDF()
+		if err != nil {
+			return nil, nil, err
+		}
+		mc.Keys[i], err = symcrypt.EncryptAesWithRand(random, capsuleKey, sharedKey, mc.associatedData())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return sharedKey, mc, nil
+}
+
+// SharedKey opens the shared key with a recipient's capsule key.
+func (mc *MultiCapsule) SharedKey(capsuleKey []byte) ([]byte, error) {
+	if mc == nil || mc.Capsule == nil {
+		return nil, fmt.Errorf("%w: multi capsule is nil", ErrInvalidCapsule)
+	}
+	ad := mc.associatedData()
+	for _, sealed := range mc.Keys {
+		sharedKey, err := symcrypt.DecryptAesWithAD(capsuleKey, sealed, ad)
+		if err == nil {
+			return sharedKey, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no shared key for this capsule key", ErrDecryptionFailed)
+}
+
+// DecapsulateMulti is DecapsulateOriginal followed by SharedKey.
+func DecapsulateMulti(priv *keys.PrivateKey, mc *MultiCapsule) ([]byte, error) {
+	if mc == nil {
+		return nil, fmt.Errorf("%w: multi capsule is nil", ErrInvalidCapsule)
+	}
+	capsuleKey, err := DecapsulateOriginal(priv, mc.Capsule)
+	if err != nil {
+		return nil, err
+	}
+	return mc.SharedKey(capsuleKey)
+}
+
+func (mc *MultiCapsule) associatedData() []byte {
+	return util.AppendByt(multiKeyDomain, mc.Capsule.Marshal())
+}
+
+// Marshal encodes mc as capsule || recipients(2) || sealed key...
+func (mc *MultiCapsule) Marshal() []byte {
+	byt := append(mc.Capsule.Marshal(), byte(len(mc.Keys)>>8), byte(len(mc.Keys)))
+	for _, sealed := range mc.Keys {
+		byt = append(byt, sealed...)
+	}
+	return byt
+}
+
+// Unmarshal decodes the encoding produced by Marshal, mc is only modified if the
+// whole input is valid.
+func (mc *MultiCapsule) Unmarshal(data []byte) error {
+	capLen := len(capsule.NewCapsule().Marshal())
+	if len(data) < capLen+2 {
+		return fmt.Errorf("%w: multi capsule data length error", ErrMalformedEncoding)
+	}
+	n := int(data[capLen])<<8 | int(data[capLen+1])
+	if n < 1 || len(data) != capLen+2+n*sealedKeyLen {
+		return fmt.Errorf("%w: multi capsule data length error", ErrMalformedEncoding)
+	}
+	capsl := capsule.NewCapsule()
+	if err := capsl.Unmarshal(data[:capLen]); err != nil {
+		return err
+	}
+	sealed := make([][]byte, n)
+	for i := range sealed {
+		off := capLen + 2 + i*sealedKeyLen
+		sealed[i] = append([]byte(nil), data[off:off+sealedKeyLen]...)
+	}
+
+	mc.Capsule = capsl
+	mc.Keys = sealed
+	return nil
+}
+
+func (mc *MultiCapsule) Hex() string {
+	return hex.EncodeToString(mc.Marshal())
+}
+
+func (mc *MultiCapsule) FromHex(s string) error {
+	byt, err := util.HexToBytes(s)
+	if err != nil {
+		return err
+	}
+	return mc.Unmarshal(byt)
+}
@@ -0,0 +1,514 @@
+package prencrypt
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// MaxHops is the largest hop count a HopCapsule can allow.
+const MaxHops = 16
+
+var hopProofDomain = []byte("prencrypt hop proof")
+
+// HopCapsule is a capsule that delegatees can forward without Alice, see Forward.
+// Capsule is Alice's original capsule and every hop proves that it re-encrypts the
+// level before it to its delegator's delegatee, so proxies can check the chain back
+// to Capsule before re-encrypting with ReEncapsulateHop. MaxHops bounds len(Hops).
+type HopCapsule struct {
+	Capsule *capsule.Capsule
+	MaxHops int
+	Hops    []*Hop
+}
+
+// Hop is one forwarding step from a delegator to a delegatee. CFrags re-encrypt
+// the previous level to the delegatee, Policy is the delegator's signed policy
+// commitment of their kfrags and Shares are their share indices, so anybody can
+// interpolate the re-encrypted E', V'. E = (d/b)*E' and V = (d/b)*V' is the
+// capsule under the delegatee's key B = b*G, where d is the precursor key of the
+// delegation. Challenge, KeyResponse and PrecursorResponse prove knowledge of b
+// and d with
+//
+//	B = b*G, b*E = d*E', b*V = d*V', d*Policy.RootKey = Policy.AlicePub
+//
+// which binds the hop to the delegatee: the last equation only holds for the d of
+// the delegation, so E, V open with b to what the previous level opened to.
+type Hop struct {
+	Policy            *kfrag.PolicyCommitment
+	CFrags            []*cfrag.CFrag
+	Shares            []*curvebn.Scalar
+	E, V              *point.Point
+	Challenge         *curvebn.Scalar
+	KeyResponse       *curvebn.Scalar
+	PrecursorResponse *curvebn.Scalar
+}
+
+// NewHopCapsule wraps c so it can be forwarded up to maxHops times.
+func NewHopCapsule(c *capsule.Capsule, maxHops int) (*HopCapsule, error) {
+	if maxHops < 1 || maxHops > MaxHops {
+		return nil, fmt.Errorf("%w: max hops %d out of range", ErrInvalidArgument, maxHops)
+	}
+	if err := verifyCapsule(c); err != nil {
+		return nil, err
+	}
+	return &HopCapsule{Capsule: c, MaxHops: maxHops}, nil
+}
+
+// current returns the capsule points of the last delegatee.
+func (hc *HopCapsule) current() (*point.Point, *point.Point) {
+	if len(hc.Hops) == 0 {
+		return hc.Capsule.E, hc.Capsule.V
+	}
+	last := hc.Hops[len(hc.Hops)-1]
+	return last.E, last.V
+}
+
+// Verify checks the original capsule, that every hop's delegator is the previous
+// hop's delegatee and the proofs of every hop. It does not show that Capsule is
+// under the first delegator's key, only that each level re-encrypts the one before.
+func (hc *HopCapsule) Verify() error {
+	if hc == nil {
+		return fmt.Errorf("%w: hop capsule is nil", ErrInvalidCapsule)
+	}
+	if err := verifyCapsule(hc.Capsule); err != nil {
+		return err
+	}
+	if hc.MaxHops < 1 || hc.MaxHops > MaxHops {
+		return fmt.Errorf("%w: max hops %d out of range", ErrInvalidCapsule, hc.MaxHops)
+	}
+	if len(hc.Hops) > hc.MaxHops {
+		return fmt.Errorf("%w: %d hops, at most %d", ErrHopLimit, len(hc.Hops), hc.MaxHops)
+	}
+	E, V := hc.Capsule.E, hc.Capsule.V
+	for i, hop := range hc.Hops {
+		if hop == nil || hop.Policy == nil || hop.Challenge == nil || hop.KeyResponse == nil || hop.PrecursorResponse == nil {
+			return fmt.Errorf("%w: hop %d is incomplete", ErrInvalidCapsule, i)
+		}
+		if err := point.ValidatePoints(hop.E, hop.V); err != nil {
+			return fmt.Errorf("%w: hop %d: %v", ErrInvalidCapsule, i, err)
+		}
+		if err := hop.Policy.Verify(hop.Policy.AlicePub, hop.Policy.BobPub); err != nil {
+			return fmt.Errorf("%w: hop %d: %v", ErrInvalidCapsule, i, err)
+		}
+		if i > 0 && !hop.Policy.AlicePub.Point.IsEqual(hc.Hops[i-1].Policy.BobPub.Point) {
+			return fmt.Errorf("%w: hop %d is not from the previous delegatee", ErrInvalidCapsule, i)
+		}
+		reE, reV, err := reEncryptedCapsule(E, V, hop.Policy, hop.CFrags, hop.Shares)
+		if err != nil {
+			return err
+		}
+		pc := hop.Policy
+		zb, zd, c := hop.KeyResponse.Int(), hop.PrecursorResponse.Int(), hop.Challenge.Int()
+		T1 := point.BaseMul(zb).Sub(pc.BobPub.Point.Mul(c))
+		T2 := hop.E.Mul(zb).Sub(reE.Mul(zd))
+		T3 := hop.V.Mul(zb).Sub(reV.Mul(zd))
+		T4 := pc.RootKey.Mul(zd).Sub(pc.AlicePub.Point.Mul(c))
+		if !hc.hopChallenge(i, pc, reE, reV, hop.E, hop.V, T1, T2, T3, T4).IsEqual(hop.Challenge) {
+			return fmt.Errorf("%w: hop %d proof verification failed", ErrInvalidCapsule, i)
+		}
+		E, V = hop.E, hop.V
+	}
+	return nil
+}
+
+// hopChallenge is SHA256(domain || capsule || max hops(2) || hop(2) || policy
+// digest || points) mod N.
+func (hc *HopCapsule) hopChallenge(hop int, pc *kfrag.PolicyCommitment, points ...*point.Point) *curvebn.Scalar {
+	h := sha256.New()
+	h.Write(hopProofDomain)
+	h.Write(hc.Capsule.Marshal())
+	var counts [4]byte
+	binary.BigEndian.PutUint16(counts[:2], uint16(hc.MaxHops))
+	binary.BigEndian.PutUint16(counts[2:], uint16(hop))
+	h.Write(counts[:])
+	h.Write(pc.Digest())
+	for _, p := range points {
+		h.Write(p.Marshal())
+	}
+	return curvebn.NewScalar(new(big.Int).SetBytes(h.Sum(nil)))
+}
+
+// reEncryptedCapsule checks cfrags against E, V and pc and interpolates the
+// re-encrypted capsule from them at the given share indices. pc must be flat: the
+// index of a sub gate needs D, which only Alice and Bob can compute.
+func reEncryptedCapsule(E, V *point.Point, pc *kfrag.PolicyCommitment, cfrags []*cfrag.CFrag, shares []*curvebn.Scalar) (*point.Point, *point.Point, error) {
+	if len(cfrags) < 1 {
+		return nil, nil, fmt.Errorf("%w: no cfrags", ErrThresholdNotMet)
+	}
+	if len(pc.Gates) != 1 {
+		return nil, nil, fmt.Errorf("%w: only flat kfrag sets can be forwarded", ErrInvalidCapsule)
+	}
+	if len(shares) != len(cfrags) {
+		return nil, nil, fmt.Errorf("%w: have %d share indices for %d cfrags", ErrInvalidCapsule, len(shares), len(cfrags))
+	}
+	if err := verifyHopCFrags(E, V, cfrags); err != nil {
+		return nil, nil, err
+	}
+	for _, s := range shares {
+		if s == nil {
+			return nil, nil, fmt.Errorf("%w: share index is nil", ErrInvalidCapsule)
+		}
+	}
+	root, err := buildPolicyTree(pc, cfrags)
+	if err != nil {
+		return nil, nil, err
+	}
+	lambdas, err := root.combine(cfrags, shares, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lambdas == nil {
+		return nil, nil, thresholdError(root, cfrags)
+	}
+	return interpolateCapsule(cfrags, lambdas)
+}
+
+// verifyHopCFrags checks the proofs of cfrags against E, V and that they are flat.
+func verifyHopCFrags(E, V *point.Point, cfrags []*cfrag.CFrag) error {
+	if err := verifyCFrags(E, V, cfrags); err != nil {
+		return err
+	}
+	return flatCFrags(cfrags)
+}
+
+// flatCFrags rejects cfrags of sub gates. Forwarding needs a flat kfrag set, as the
+// indices of sub gates need D.
+func flatCFrags(cfrags []*cfrag.CFrag) error {
+	for _, cfrag := range cfrags {
+		if cfrag != nil && len(cfrag.Path) != 0 {
+			return &ErrInvalidCFrag{Id: cfrag.Id, Reason: "cfrags of a policy cannot be forwarded"}
+		}
+	}
+	return nil
+}
+
+// interpolateCapsule returns sum(lambda_i*E1_i), sum(lambda_i*V1_i).
+func interpolateCapsule(cfrags []*cfrag.CFrag, lambdas map[int]*curvebn.Scalar) (*point.Point, *point.Point, error) {
+	es := make([]*point.Point, 0, len(lambdas))
+	vs := make([]*point.Point, 0, len(lambdas))
+	scalars := make([]*big.Int, 0, len(lambdas))
+	for index, cfrag := range cfrags {
+		lambda, ok := lambdas[index]
+		if !ok {
+			continue
+		}
+		es, vs = append(es, cfrag.E1), append(vs, cfrag.V1)
+		scalars = append(scalars, lambda.Int())
+	}
+	E, err := point.MultiMul(es, scalars)
+	if err != nil {
+		return nil, nil, err
+	}
+	V, err := point.MultiMul(vs, scalars)
+	if err != nil {
+		return nil, nil, err
+	}
+	return E, V, nil
+}
+
+func Forward(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, hc *HopCapsule, cfrags []*cfrag.CFrag) (*HopCapsule, error) {
+	return ForwardWithRand(nil, privBob, pubAlice, pc, hc, cfrags)
+}
+
+// ForwardWithRand turns the cfrags Bob received for hc, from proxies holding
+// Alice's kfrags, into a capsule under Bob's own key. Bob can then issue kfrags to
+// Carol with KfragsGen(privBob, carolPub, ...), proxies re-encrypt the result
+// with ReEncapsulateHop and Carol opens it with DecapsulateHopFrags(privCarol,
+// privBob.PublicKey, pc, hc, cfrags). pubAlice is the key of the last delegator and
+// pc the policy commitment of its kfrags, and Carol can forward again while hc
+// allows more hops. The share indices of the cfrags become public with the hop.
+func ForwardWithRand(random io.Reader, privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, hc *HopCapsule, cfrags []*cfrag.CFrag) (*HopCapsule, error) {
+	if privBob == nil {
+		return nil, fmt.Errorf("%w: private key is nil", ErrInvalidKey)
+	}
+	if err := pubAlice.Validate(); err != nil {
+		return nil, err
+	}
+	if err := pc.Validate(); err != nil {
+		return nil, err
+	}
+	if err := hc.Verify(); err != nil {
+		return nil, err
+	}
+	if len(hc.Hops) >= hc.MaxHops {
+		return nil, fmt.Errorf("%w: capsule was forwarded %d times", ErrHopLimit, len(hc.Hops))
+	}
+	if len(pc.Gates) != 1 {
+		return nil, fmt.Errorf("%w: only flat kfrag sets can be forwarded", ErrInvalidCapsule)
+	}
+	if len(hc.Hops) > 0 && !pubAlice.Point.IsEqual(hc.Hops[len(hc.Hops)-1].Policy.BobPub.Point) {
+		return nil, fmt.Errorf("%w: the capsule was forwarded to another key", ErrInvalidCapsule)
+	}
+	if err := flatCFrags(cfrags); err != nil {
+		return nil, err
+	}
+	E, V := hc.current()
+	lambdas, S, d, err := combineCFrags(privBob, pubAlice, pc, E, V, cfrags)
+	if err != nil {
+		return nil, err
+	}
+	reE, reV, err := interpolateCapsule(cfrags, lambdas)
+	if err != nil {
+		return nil, err
+	}
+
+	// b*E = d*E', b*V = d*V'
+	b := privBob.Scalar()
+	k := d.Mul(b.Inverse())
+	hop := &Hop{Policy: pc, CFrags: cfrags, Shares: S, E: reE.MulBytes(k.Bytes()), V: reV.MulBytes(k.Bytes())}
+	rb, err := curvebn.RandomScalarWithRand(random)
+	if err != nil {
+		return nil, err
+	}
+	rd, err := curvebn.RandomScalarWithRand(random)
+	if err != nil {
+		return nil, err
+	}
+	T1 := point.BaseMulBytes(rb.Bytes())
+	T2 := hop.E.MulBytes(rb.Bytes()).Sub(reE.MulBytes(rd.Bytes()))
+	T3 := hop.V.MulBytes(rb.Bytes()).Sub(reV.MulBytes(rd.Bytes()))
+	T4 := pc.RootKey.MulBytes(rd.Bytes())
+	hop.Challenge = hc.hopChallenge(len(hc.Hops), pc, reE, reV, hop.E, hop.V, T1, T2, T3, T4)
+	hop.KeyResponse = rb.Add(hop.Challenge.Mul(b))
+	hop.PrecursorResponse = rd.Add(hop.Challenge.Mul(d))
+
+	hops := append(append([]*Hop(nil), hc.Hops...), hop)
+	return &HopCapsule{Capsule: hc.Capsule, MaxHops: hc.MaxHops, Hops: hops}, nil
+}
+
+func ReEncapsulateHop(kfrag *kfrag.KFrag, hc *HopCapsule, aux []byte) (*cfrag.CFrag, error) {
+	return ReEncapsulateHopWithRand(nil, kfrag, hc, aux)
+}
+
+// ReEncapsulateHopWithRand is ReEncapsulateWithRand for the last delegatee of hc,
+// after checking the chain of hops back to the original capsule.
+func ReEncapsulateHopWithRand(random io.Reader, kfrag *kfrag.KFrag, hc *HopCapsule, aux []byte) (*cfrag.CFrag, error) {
+	if err := kfrag.Validate(); err != nil {
+		return nil, err
+	}
+	if err := hc.Verify(); err != nil {
+		return nil, err
+	}
+	if len(hc.Hops) == 0 {
+		return ReEncapsulateWithRand(random, kfrag, hc.Capsule, aux)
+	}
+	E, V := hc.current()
+	t, err := curvebn.RandomScalarWithRand(random)
+	if err != nil {
+		return nil, err
+	}
+	cfrg, err := reEncapsulate(kfrag, point.NewScalarMul(kfrag.Rk.Bytes()), &capsule.Capsule{E: E, V: V}, aux, t)
+	if err != nil {
+		return nil, err
+	}
+	if !cfrg.Verify(E, V) {
+		return nil, &ErrInvalidCFrag{Id: kfrag.Id, Reason: "proof verification failed"}
+	}
+	return cfrg, nil
+}
+
+// DecapsulateHopFrags is DecapsulateFrags for the cfrags proxies re-encrypted hc
+// into with ReEncapsulateHop, after checking the chain of hops back to the
+// original capsule. pubAlice is the key of the last delegatee of hc.
+func DecapsulateHopFrags(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, hc *HopCapsule, cfrags []*cfrag.CFrag) ([]byte, error) {
+	if err := hc.Verify(); err != nil {
+		return nil, err
+	}
+	E, V := hc.current()
+	return decapsulateFrags(privBob, pubAlice, pc, E, V, cfrags)
+}
+
+// Marshal encodes hc as len(2) capsule || max hops(1) || hops(1) || (len(4) hop)...,
+// see Hop.Marshal. A field too long for its length prefix is an ErrInvalidArgument.
+func (hc *HopCapsule) Marshal() ([]byte, error) {
+	if hc == nil || hc.Capsule == nil {
+		return nil, fmt.Errorf("%w: hop capsule is incomplete", ErrInvalidArgument)
+	}
+	if hc.MaxHops < 1 || hc.MaxHops > MaxHops || len(hc.Hops) > hc.MaxHops {
+		return nil, fmt.Errorf("%w: max hops %d out of range", ErrInvalidArgument, hc.MaxHops)
+	}
+	byt, err := appendLenPrefixed(nil, hc.Capsule.Marshal(), 2)
+	if err != nil {
+		return nil, err
+	}
+	byt = append(byt, byte(hc.MaxHops), byte(len(hc.Hops)))
+	for _, hop := range hc.Hops {
+		hopByt, err := hop.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if byt, err = appendLenPrefixed(byt, hopByt, 4); err != nil {
+			return nil, err
+		}
+	}
+	return byt, nil
+}
+
+// Unmarshal decodes the encoding produced by Marshal, hc is only modified if the
+// whole input is valid. The hops are decoded but not verified, see Verify.
+func (hc *HopCapsule) Unmarshal(data []byte) error {
+	field, data, err := readLenPrefixed(data, 2)
+	if err != nil {
+		return err
+	}
+	capsl := capsule.NewCapsule()
+	if err := capsl.Unmarshal(field); err != nil {
+		return err
+	}
+	if len(data) < 2 {
+		return fmt.Errorf("%w: hop capsule data length error", ErrMalformedEncoding)
+	}
+	maxHops, count := int(data[0]), int(data[1])
+	data = data[2:]
+	if maxHops < 1 || maxHops > MaxHops || count > maxHops {
+		return fmt.Errorf("%w: max hops %d out of range", ErrMalformedEncoding, maxHops)
+	}
+	hops := make([]*Hop, count)
+	for i := range hops {
+		if field, data, err = readLenPrefixed(data, 4); err != nil {
+			return err
+		}
+		hops[i] = new(Hop)
+		if err := hops[i].Unmarshal(field); err != nil {
+			return err
+		}
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%w: hop capsule data length error", ErrMalformedEncoding)
+	}
+	hc.Capsule, hc.MaxHops, hc.Hops = capsl, maxHops, hops
+	return nil
+}
+
+func (hc *HopCapsule) Hex() (string, error) {
+	byt, err := hc.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(byt), nil
+}
+
+func (hc *HopCapsule) FromHex(s string) error {
+	byt, err := util.HexToBytes(s)
+	if err != nil {
+		return err
+	}
+	return hc.Unmarshal(byt)
+}
+
+// Marshal encodes the hop as
+//
+//	len(4) policy || cfrags(2) || (len(4) cfrag)... || (len(1) share)... ||
+//	    len(1) E || len(1) V || len(1) challenge || len(1) key response ||
+//	    len(1) precursor response
+//
+// with one share index per cfrag. The cfrags carry their proofs, so a decoded hop
+// verifies like the original. A field too long for its length prefix is an
+// ErrInvalidArgument.
+func (hop *Hop) Marshal() ([]byte, error) {
+	if hop == nil || hop.Policy == nil || hop.E == nil || hop.V == nil || hop.Challenge == nil || hop.KeyResponse == nil || hop.PrecursorResponse == nil {
+		return nil, fmt.Errorf("%w: hop is incomplete", ErrInvalidArgument)
+	}
+	if len(hop.Shares) != len(hop.CFrags) || len(hop.CFrags) > 0xffff {
+		return nil, fmt.Errorf("%w: have %d share indices for %d cfrags", ErrInvalidArgument, len(hop.Shares), len(hop.CFrags))
+	}
+	byt, err := appendLenPrefixed(nil, hop.Policy.Marshal(), 4)
+	if err != nil {
+		return nil, err
+	}
+	byt = binary.BigEndian.AppendUint16(byt, uint16(len(hop.CFrags)))
+	for _, cfrag := range hop.CFrags {
+		if err := cfrag.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+		}
+		if byt, err = appendLenPrefixed(byt, cfrag.Marshal(), 4); err != nil {
+			return nil, err
+		}
+	}
+	fields := make([][]byte, 0, len(hop.Shares)+5)
+	for _, s := range hop.Shares {
+		if s == nil {
+			return nil, fmt.Errorf("%w: share index is nil", ErrInvalidArgument)
+		}
+		fields = append(fields, s.Bytes())
+	}
+	fields = append(fields, hop.E.Marshal(), hop.V.Marshal(), hop.Challenge.Bytes(), hop.KeyResponse.Bytes(), hop.PrecursorResponse.Bytes())
+	for _, field := range fields {
+		if byt, err = appendLenPrefixed(byt, field, 1); err != nil {
+			return nil, err
+		}
+	}
+	return byt, nil
+}
+
+// Unmarshal decodes the encoding produced by Marshal, hop is only modified if the
+// whole input is valid. The hop is decoded but not verified, see HopCapsule.Verify.
+func (hop *Hop) Unmarshal(data []byte) error {
+	field, data, err := readLenPrefixed(data, 4)
+	if err != nil {
+		return err
+	}
+	pc := new(kfrag.PolicyCommitment)
+	if err := pc.Unmarshal(field); err != nil {
+		return err
+	}
+	if len(data) < 2 {
+		return fmt.Errorf("%w: hop data length error", ErrMalformedEncoding)
+	}
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	cfrags := make([]*cfrag.CFrag, count)
+	for i := range cfrags {
+		if field, data, err = readLenPrefixed(data, 4); err != nil {
+			return err
+		}
+		cfrags[i] = cfrag.NewCFrag()
+		if err := cfrags[i].Unmarshal(field); err != nil {
+			return err
+		}
+	}
+	scalars := make([]*curvebn.Scalar, count+3)
+	points := make([]*point.Point, 2)
+	for i := 0; i < count+5; i++ {
+		if field, data, err = readLenPrefixed(data, 1); err != nil {
+			return err
+		}
+		switch {
+		case i < count:
+			scalars[i], err = curvebn.ScalarFromBytes(field)
+		case i < count+2:
+			points[i-count] = point.NewPoint()
+			err = points[i-count].Unmarshal(field)
+		default:
+			scalars[i-2], err = curvebn.ScalarFromBytes(field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%w: hop data length error", ErrMalformedEncoding)
+	}
+	*hop = Hop{
+		Policy:            pc,
+		CFrags:            cfrags,
+		Shares:            scalars[:count],
+		E:                 points[0],
+		V:                 points[1],
+		Challenge:         scalars[count],
+		KeyResponse:       scalars[count+1],
+		PrecursorResponse: scalars[count+2],
+	}
+	return nil
+}
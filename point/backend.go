@@ -0,0 +1,146 @@
+package point
+
+import (
+	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hongyuefan/prencrypt/internal/ctcurve"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// Backend selects the implementation of the group arithmetic behind Point.
+type Backend int32
+
+const (
+	// ConstantTime runs scalar multiplication in time independent of the scalar.
+	ConstantTime Backend = iota
+	// Generic uses the big.Int arithmetic of util.Curve, which is not constant time.
+	Generic
+)
+
+var backend int32 = int32(ConstantTime)
+
+// SetBackend switches the arithmetic used by Point and curvebn for the whole process.
+func SetBackend(b Backend) {
+	atomic.StoreInt32(&backend, int32(b))
+}
+
+func GetBackend() Backend {
+	return Backend(atomic.LoadInt32(&backend))
+}
+
+// fixedScalar reduces a big-endian scalar of at most 32 bytes modulo N in
+// constant time.
+func fixedScalar(k []byte) *ctcurve.Scalar {
+	var buf [32]byte
+	copy(buf[32-len(k):], k)
+	s := ctcurve.ScalarFromBytes(&buf)
+	return &s
+}
+
+func bigScalar(k *big.Int) *ctcurve.Scalar {
+	s := ctcurve.ScalarFromBig(k)
+	return &s
+}
+
+func scalarMult(x, y *big.Int, k *ctcurve.Scalar) (*big.Int, *big.Int) {
+	if GetBackend() == Generic {
+		if x.Sign() == 0 && y.Sign() == 0 {
+			return new(big.Int), new(big.Int)
+		}
+		b := k.Bytes()
+		return util.Curve.ScalarMult(x, y, b[:])
+	}
+	return ctcurve.ScalarMult(x, y, k)
+}
+
+func scalarBaseMult(k *ctcurve.Scalar) (*big.Int, *big.Int) {
+	if GetBackend() == Generic {
+		b := k.Bytes()
+		return util.Curve.ScalarBaseMult(b[:])
+	}
+	return ctcurve.ScalarBaseMult(k)
+}
+
+var (
+	uTable     *ctcurve.FixedBase
+	uTableOnce sync.Once
+)
+
+func scalarUMult(k *ctcurve.Scalar) (*big.Int, *big.Int) {
+	if GetBackend() == Generic {
+		u := UPoint()
+		return scalarMult(u.X, u.Y, k)
+	}
+	uTableOnce.Do(func() {
+		u := UPoint()
+		uTable = ctcurve.NewFixedBase(u.X, u.Y)
+	})
+	return uTable.Mul(k)
+}
+
+func multiScalarMult(xs, ys []*big.Int, ks []ctcurve.Scalar) (*big.Int, *big.Int) {
+	if GetBackend() == Generic {
+		x, y := new(big.Int), new(big.Int)
+		for i := range ks {
+			px, py := scalarMult(xs[i], ys[i], &ks[i])
+			x, y = add(x, y, px, py)
+		}
+		return x, y
+	}
+	return ctcurve.MultiScalarMult(xs, ys, ks)
+}
+
+// ScalarMul multiplies many points by the same scalar, decomposing the scalar once.
+type ScalarMul struct {
+	k *ctcurve.Scalar
+	m *ctcurve.Multiplier
+}
+
+// NewScalarMul takes the scalar as at most 32 big-endian bytes, like MulBytes.
+func NewScalarMul(k []byte) *ScalarMul {
+	s := fixedScalar(k)
+	return &ScalarMul{k: s, m: ctcurve.NewMultiplier(s)}
+}
+
+func (s *ScalarMul) Mul(p *Point) *Point {
+	var x, y *big.Int
+	if GetBackend() == Generic {
+		x, y = scalarMult(p.X, p.Y, s.k)
+	} else {
+		x, y = s.m.Mul(p.X, p.Y)
+	}
+	return &Point{
+		Curve: p.Curve,
+		X:     x,
+		Y:     y,
+	}
+}
+
+func add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	if GetBackend() == Generic {
+		switch {
+		case x1.Sign() == 0 && y1.Sign() == 0:
+			return new(big.Int).Set(x2), new(big.Int).Set(y2)
+		case x2.Sign() == 0 && y2.Sign() == 0:
+			return new(big.Int).Set(x1), new(big.Int).Set(y1)
+		case x1.Cmp(x2) == 0 && y1.Cmp(y2) != 0:
+			// P + (-P)
+			return new(big.Int), new(big.Int)
+		case x1.Cmp(x2) == 0:
+			return util.Curve.Double(x1, y1)
+		}
+		return util.Curve.Add(x1, y1, x2, y2)
+	}
+	return ctcurve.Add(x1, y1, x2, y2)
+}
+
+// ScalarInverse returns k^-1 mod N using the selected backend.
+func ScalarInverse(k *big.Int) *big.Int {
+	if GetBackend() == Generic {
+		return new(big.Int).ModInverse(k, util.Curve.Params().N)
+	}
+	inv := bigScalar(k).Inverse()
+	return inv.Big()
+}
@@ -0,0 +1,35 @@
+package point
+
+import (
+	"math/big"
+	"testing"
+)
+
+func FuzzPointUnmarshal(f *testing.F) {
+	p := BaseMul(big.NewInt(12345))
+	f.Add(p.Marshal())
+	f.Add(p.MarshalUncompressed())
+	f.Add(Identity().Marshal())
+	f.Add([]byte{})
+	f.Add([]byte{2})
+	f.Add([]byte{4, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := NewPoint()
+		if err := p.Unmarshal(data); err != nil {
+			return
+		}
+		if !p.IsIdentity() && !p.Curve.IsOnCurve(p.X, p.Y) {
+			t.Fatal("decoded point is not on the curve")
+		}
+		for _, enc := range [][]byte{p.Marshal(), p.MarshalUncompressed()} {
+			q := NewPoint()
+			if err := q.Unmarshal(enc); err != nil {
+				t.Fatalf("re-encoded point does not decode: %v", err)
+			}
+			if !q.IsEqual(p) {
+				t.Fatal("point round trip mismatch")
+			}
+		}
+	})
+}
@@ -4,18 +4,23 @@ import (
 	"bytes"
 	"crypto/elliptic"
 	"encoding/hex"
-	"errors"
+	"fmt"
 	"math/big"
+	"sync"
 
+	"github.com/hongyuefan/prencrypt/internal/ctcurve"
 	"github.com/hongyuefan/prencrypt/util"
 )
 
+// Point is a point on util.Curve. As in crypto/elliptic, the point at infinity
+// is represented by X = Y = 0.
 type Point struct {
 	Curve elliptic.Curve
 	X     *big.Int
 	Y     *big.Int
 }
 
+// NewPoint returns the identity, to be filled in by Unmarshal.
 func NewPoint() *Point {
 	return &Point{
 		Curve: util.Curve,
@@ -24,8 +29,27 @@ func NewPoint() *Point {
 	}
 }
 
+// Identity returns the point at infinity.
+func Identity() *Point {
+	return NewPoint()
+}
+
+func (p *Point) IsIdentity() bool {
+	return p.X.Sign() == 0 && p.Y.Sign() == 0
+}
+
 func (p *Point) Mul(m *big.Int) *Point {
-	x, y := p.Curve.ScalarMult(p.X, p.Y, m.Bytes())
+	return p.mul(bigScalar(m))
+}
+
+// MulBytes returns k*p for a big-endian scalar k of at most 32 bytes, which is
+// reduced modulo N without going through big.Int. Use it for secret scalars.
+func (p *Point) MulBytes(k []byte) *Point {
+	return p.mul(fixedScalar(k))
+}
+
+func (p *Point) mul(k *ctcurve.Scalar) *Point {
+	x, y := scalarMult(p.X, p.Y, k)
 	return &Point{
 		Curve: p.Curve,
 		X:     x,
@@ -34,9 +58,81 @@ func (p *Point) Mul(m *big.Int) *Point {
 }
 
 func (p *Point) Add(u *Point) *Point {
-	x, y := p.Curve.Add(p.X, p.Y, u.X, u.Y)
+	x, y := add(p.X, p.Y, u.X, u.Y)
+	return &Point{
+		Curve: p.Curve,
+		X:     x,
+		Y:     y,
+	}
+}
+
+// MultiMul returns scalars[0]*points[0] + ... + scalars[n-1]*points[n-1],
+// sharing the doublings between all terms.
+func MultiMul(points []*Point, scalars []*big.Int) (*Point, error) {
+	ks := make([]ctcurve.Scalar, len(scalars))
+	for i, k := range scalars {
+		ks[i] = *bigScalar(k)
+	}
+	return multiMul(points, ks)
+}
+
+// MultiMulBytes is MultiMul for scalars given as in MulBytes.
+func MultiMulBytes(points []*Point, scalars [][]byte) (*Point, error) {
+	ks := make([]ctcurve.Scalar, len(scalars))
+	for i, k := range scalars {
+		ks[i] = *fixedScalar(k)
+	}
+	return multiMul(points, ks)
+}
+
+func multiMul(points []*Point, scalars []ctcurve.Scalar) (*Point, error) {
+	if len(points) != len(scalars) {
+		return nil, fmt.Errorf("%w: points and scalars length mismatch", util.ErrInvalidArgument)
+	}
+	xs := make([]*big.Int, len(points))
+	ys := make([]*big.Int, len(points))
+	for i, p := range points {
+		xs[i], ys[i] = p.X, p.Y
+	}
+	x, y := multiScalarMult(xs, ys, scalars)
+	return &Point{
+		Curve: util.Curve,
+		X:     x,
+		Y:     y,
+	}, nil
+}
+
+// Neg returns -p.
+func (p *Point) Neg() *Point {
+	if p.IsIdentity() {
+		return Identity()
+	}
 	return &Point{
 		Curve: p.Curve,
+		X:     new(big.Int).Set(p.X),
+		Y:     new(big.Int).Sub(p.Curve.Params().P, p.Y),
+	}
+}
+
+// Sub returns p - u.
+func (p *Point) Sub(u *Point) *Point {
+	return p.Add(u.Neg())
+}
+
+// BaseMul returns m*G.
+func BaseMul(m *big.Int) *Point {
+	return baseMul(bigScalar(m))
+}
+
+// BaseMulBytes returns k*G for k given as in MulBytes.
+func BaseMulBytes(k []byte) *Point {
+	return baseMul(fixedScalar(k))
+}
+
+func baseMul(k *ctcurve.Scalar) *Point {
+	x, y := scalarBaseMult(k)
+	return &Point{
+		Curve: util.Curve,
 		X:     x,
 		Y:     y,
 	}
@@ -50,11 +146,33 @@ func (p *Point) KDF() (key []byte, err error) {
 	return util.Kdf(secret.Bytes())
 }
 
-// Marshal converts a point into the uncompressed form specified in section 4.3.6 of ANSI X9.62.
+func (p *Point) byteLen() int {
+	return (p.Curve.Params().BitSize + 7) >> 3
+}
+
+// Marshal converts a point into the compressed form specified in section 2.3.3 of SEC 1.
+// The identity is encoded as Len() zero bytes, so every point has the same length.
 func (p *Point) Marshal() []byte {
-	byteLen := (p.Curve.Params().BitSize + 7) >> 3
+	byteLen := p.byteLen()
+	ret := make([]byte, 1+byteLen)
+	if p.IsIdentity() {
+		return ret
+	}
+	ret[0] = 2 | byte(p.Y.Bit(0)) // compressed point
+
+	xBytes := p.X.Bytes()
+	copy(ret[1+byteLen-len(xBytes):], xBytes)
+	return ret
+}
 
+// MarshalUncompressed converts a point into the uncompressed form specified in section 4.3.6 of ANSI X9.62.
+// The identity is encoded as zero bytes of the same length.
+func (p *Point) MarshalUncompressed() []byte {
+	byteLen := p.byteLen()
 	ret := make([]byte, 1+2*byteLen)
+	if p.IsIdentity() {
+		return ret
+	}
 	ret[0] = 4 // uncompressed point
 
 	xBytes := p.X.Bytes()
@@ -64,32 +182,71 @@ func (p *Point) Marshal() []byte {
 	return ret
 }
 
+// Len returns the length of the compressed encoding produced by Marshal.
 func (p *Point) Len() int {
-	byteLen := (p.Curve.Params().BitSize + 7) >> 3
-	return 1 + 2*byteLen
+	return 1 + p.byteLen()
 }
 
-// Unmarshal converts a point, serialized by Marshal, into an x, y pair.
-// It is an error if the point is not in uncompressed form or is not on the curve.
-// On error, x = nil.
+// Unmarshal decodes a point in compressed or uncompressed form, where all zero
+// bytes of either length stand for the identity. It is an error if the point is
+// not on the curve.
 func (p *Point) Unmarshal(data []byte) error {
-	byteLen := (p.Curve.Params().BitSize + 7) >> 3
-	if len(data) != 1+2*byteLen {
-		return errors.New("point data length error")
-	}
-	if data[0] != 4 { // uncompressed form
-		return errors.New("data uncompressed form")
+	byteLen := p.byteLen()
+	if len(data) == 0 {
+		return fmt.Errorf("%w: point data length error", util.ErrMalformedEncoding)
 	}
 	pBig := p.Curve.Params().P
-	p.X = new(big.Int).SetBytes(data[1 : 1+byteLen])
-	p.Y = new(big.Int).SetBytes(data[1+byteLen:])
-	if p.X.Cmp(pBig) >= 0 || p.Y.Cmp(pBig) >= 0 {
-		return errors.New("x/y too big")
-	}
-	if !p.Curve.IsOnCurve(p.X, p.Y) {
-		return errors.New("x,y is not on the curve")
+
+	switch data[0] {
+	case 0: // identity
+		if len(data) != 1+byteLen && len(data) != 1+2*byteLen {
+			return fmt.Errorf("%w: point data length error", util.ErrMalformedEncoding)
+		}
+		for _, b := range data[1:] {
+			if b != 0 {
+				return fmt.Errorf("%w: identity encoding is not zero", util.ErrMalformedEncoding)
+			}
+		}
+		p.X, p.Y = big.NewInt(0), big.NewInt(0)
+		return nil
+	case 2, 3: // compressed form
+		if len(data) != 1+byteLen {
+			return fmt.Errorf("%w: point data length error", util.ErrMalformedEncoding)
+		}
+		x := new(big.Int).SetBytes(data[1:])
+		if x.Cmp(pBig) >= 0 {
+			return fmt.Errorf("%w: x too big", util.ErrMalformedEncoding)
+		}
+		// y^2 = x^3 + b
+		y := new(big.Int).Mul(x, x)
+		y.Mul(y, x)
+		y.Add(y, p.Curve.Params().B)
+		y.Mod(y, pBig)
+		if y.ModSqrt(y, pBig) == nil {
+			return fmt.Errorf("%w: x is not on the curve", util.ErrMalformedEncoding)
+		}
+		if y.Bit(0) != uint(data[0]&1) {
+			y.Sub(pBig, y)
+		}
+		p.X, p.Y = x, y
+		return nil
+	case 4: // uncompressed form
+		if len(data) != 1+2*byteLen {
+			return fmt.Errorf("%w: point data length error", util.ErrMalformedEncoding)
+		}
+		x := new(big.Int).SetBytes(data[1 : 1+byteLen])
+		y := new(big.Int).SetBytes(data[1+byteLen:])
+		if x.Cmp(pBig) >= 0 || y.Cmp(pBig) >= 0 {
+			return fmt.Errorf("%w: x/y too big", util.ErrMalformedEncoding)
+		}
+		if !p.Curve.IsOnCurve(x, y) {
+			return fmt.Errorf("%w: x,y is not on the curve", util.ErrMalformedEncoding)
+		}
+		p.X, p.Y = x, y
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown point encoding", util.ErrMalformedEncoding)
 	}
-	return nil
 }
 
 func (p *Point) IsEqual(u *Point) bool {
@@ -102,9 +259,40 @@ func (p *Point) IsEqual(u *Point) bool {
 	return false
 }
 
+var (
+	uPoint     *Point
+	uPointOnce sync.Once
+)
+
+// UPoint returns a copy of the second generator U, decoded once.
 func UPoint() *Point {
-	byt, _ := hex.DecodeString("04fd69424254b879cecca99180f42aa9687b2d33fb3c4824c18f88ceaaff637cb145fdf0f656e0028f0918f4faefe38e8b01f686f5b31f9665b9c8876ec4787767")
-	uP := NewPoint()
-	uP.Unmarshal(byt)
-	return uP
+	uPointOnce.Do(func() {
+		byt, _ := hex.DecodeString("04fd69424254b879cecca99180f42aa9687b2d33fb3c4824c18f88ceaaff637cb145fdf0f656e0028f0918f4faefe38e8b01f686f5b31f9665b9c8876ec4787767")
+		uPoint = NewPoint()
+		uPoint.Unmarshal(byt)
+	})
+	return &Point{
+		Curve: uPoint.Curve,
+		X:     new(big.Int).Set(uPoint.X),
+		Y:     new(big.Int).Set(uPoint.Y),
+	}
+}
+
+// UMul returns m*U.
+func UMul(m *big.Int) *Point {
+	return uMul(bigScalar(m))
+}
+
+// UMulBytes returns k*U for k given as in MulBytes.
+func UMulBytes(k []byte) *Point {
+	return uMul(fixedScalar(k))
+}
+
+func uMul(k *ctcurve.Scalar) *Point {
+	x, y := scalarUMult(k)
+	return &Point{
+		Curve: util.Curve,
+		X:     x,
+		Y:     y,
+	}
 }
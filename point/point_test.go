@@ -0,0 +1,148 @@
+package point
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/hongyuefan/prencrypt/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendsAgree(t *testing.T) {
+	defer SetBackend(GetBackend())
+
+	k, _ := rand.Int(rand.Reader, util.Curve.Params().N)
+	m, _ := rand.Int(rand.Reader, util.Curve.Params().N)
+
+	SetBackend(Generic)
+	want := BaseMul(k).Mul(m).Add(UPoint())
+
+	SetBackend(ConstantTime)
+	got := BaseMul(k).Mul(m).Add(UPoint())
+
+	assert.True(t, want.IsEqual(got))
+
+	// scalars outside [0, N) are reduced the same way by both backends
+	neg := new(big.Int).Neg(k)
+	wide := new(big.Int).Add(k, util.Curve.Params().N)
+	SetBackend(Generic)
+	wantNeg, wantWide := UPoint().Mul(neg), BaseMul(wide)
+	SetBackend(ConstantTime)
+	assert.True(t, wantNeg.IsEqual(UPoint().Mul(neg)))
+	assert.True(t, wantNeg.IsEqual(UMul(k).Neg()))
+	assert.True(t, wantWide.IsEqual(BaseMul(k)))
+
+	for _, b := range []Backend{Generic, ConstantTime} {
+		SetBackend(b)
+		kb := k.FillBytes(make([]byte, 32))
+		assert.True(t, BaseMulBytes(kb).IsEqual(BaseMul(k)))
+		assert.True(t, UMulBytes(kb).IsEqual(UMul(k)))
+		assert.True(t, UPoint().MulBytes(kb).IsEqual(UPoint().Mul(k)))
+		assert.True(t, NewScalarMul(kb).Mul(UPoint()).IsEqual(UMul(k)))
+	}
+}
+
+func benchmarkMul(b *testing.B, backend Backend) {
+	defer SetBackend(GetBackend())
+	SetBackend(backend)
+
+	k, _ := rand.Int(rand.Reader, util.Curve.Params().N)
+	u := UPoint()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u.Mul(k)
+	}
+}
+
+func BenchmarkMulConstantTime(b *testing.B) {
+	benchmarkMul(b, ConstantTime)
+}
+
+func BenchmarkMulGeneric(b *testing.B) {
+	benchmarkMul(b, Generic)
+}
+
+func TestPointAPI(t *testing.T) {
+	defer SetBackend(GetBackend())
+	for _, backend := range []Backend{ConstantTime, Generic} {
+		SetBackend(backend)
+		testPointAPI(t)
+	}
+}
+
+func testPointAPI(t *testing.T) {
+	k, _ := rand.Int(rand.Reader, util.Curve.Params().N)
+	p := BaseMul(k)
+
+	assert.True(t, p.Add(p.Neg()).IsIdentity())
+	assert.True(t, p.Sub(p).IsIdentity())
+	assert.True(t, p.Add(Identity()).IsEqual(p))
+	assert.True(t, Identity().Add(p).IsEqual(p))
+	assert.True(t, p.Add(p).IsEqual(p.Mul(big.NewInt(2))))
+	assert.True(t, BaseMul(util.Curve.Params().N).IsIdentity())
+
+	for _, want := range []*Point{p, p.Neg()} {
+		for _, byt := range [][]byte{want.Marshal(), want.MarshalUncompressed()} {
+			decoded := NewPoint()
+			if !assert.NoError(t, decoded.Unmarshal(byt)) {
+				return
+			}
+			assert.True(t, decoded.IsEqual(want))
+		}
+	}
+	assert.Len(t, p.Marshal(), p.Len())
+
+	assert.Len(t, Identity().Marshal(), p.Len())
+	for _, byt := range [][]byte{Identity().Marshal(), Identity().MarshalUncompressed()} {
+		decoded := NewPoint()
+		assert.NoError(t, decoded.Unmarshal(byt))
+		assert.True(t, decoded.IsIdentity())
+	}
+	notZero := Identity().Marshal()
+	notZero[len(notZero)-1] = 1
+	assert.Error(t, NewPoint().Unmarshal(notZero))
+	assert.Error(t, NewPoint().Unmarshal([]byte{0}))
+}
+
+func TestFixedBase(t *testing.T) {
+	k, _ := rand.Int(rand.Reader, util.Curve.Params().N)
+	assert.True(t, UMul(k).IsEqual(UPoint().Mul(k)))
+	x, y := util.Curve.ScalarBaseMult(k.Bytes())
+	assert.True(t, BaseMul(k).IsEqual(&Point{Curve: util.Curve, X: x, Y: y}))
+}
+
+func BenchmarkBaseMul(b *testing.B) {
+	k, _ := rand.Int(rand.Reader, util.Curve.Params().N)
+	BaseMul(k)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BaseMul(k)
+	}
+}
+
+func BenchmarkUMul(b *testing.B) {
+	k, _ := rand.Int(rand.Reader, util.Curve.Params().N)
+	UMul(k)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		UMul(k)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p := BaseMul(big.NewInt(7))
+	assert.NoError(t, p.Validate())
+
+	assert.Error(t, (*Point)(nil).Validate())
+	assert.True(t, errors.Is(Identity().Validate(), util.ErrInvalidArgument))
+
+	off := &Point{Curve: p.Curve, X: new(big.Int).Set(p.X), Y: new(big.Int).Add(p.Y, big.NewInt(1))}
+	assert.Error(t, off.Validate())
+
+	unreduced := &Point{Curve: p.Curve, X: new(big.Int).Add(p.X, p.Curve.Params().P), Y: p.Y}
+	assert.Error(t, unreduced.Validate())
+
+	assert.Error(t, ValidatePoints(p, Identity()))
+}
@@ -0,0 +1,40 @@
+package point
+
+import (
+	"fmt"
+
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// Validate checks that p is a point of util.Curve other than the identity, with
+// coordinates reduced mod P. Every point that comes from outside the library, as a
+// key or as part of a capsule, kfrag or cfrag, must pass it before it is used.
+func (p *Point) Validate() error {
+	if p == nil || p.X == nil || p.Y == nil {
+		return fmt.Errorf("%w: point is nil", util.ErrInvalidArgument)
+	}
+	if p.IsIdentity() {
+		return fmt.Errorf("%w: point at infinity", util.ErrInvalidArgument)
+	}
+	if p.Curve != util.Curve {
+		return fmt.Errorf("%w: point is not on the curve", util.ErrInvalidArgument)
+	}
+	P := util.Curve.Params().P
+	if p.X.Sign() < 0 || p.Y.Sign() < 0 || p.X.Cmp(P) >= 0 || p.Y.Cmp(P) >= 0 {
+		return fmt.Errorf("%w: point coordinates out of range", util.ErrInvalidArgument)
+	}
+	if !util.Curve.IsOnCurve(p.X, p.Y) {
+		return fmt.Errorf("%w: point is not on the curve", util.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// ValidatePoints returns the first error of Validate over points.
+func ValidatePoints(points ...*Point) error {
+	for _, p := range points {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
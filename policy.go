@@ -0,0 +1,202 @@
+package prencrypt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+// KfragsGenPolicy issues kfrags for a threshold tree instead of a flat t-of-N
+// set, see kfrag.Policy. It returns the kfrags of each leaf in the order of
+// policy.Leaves() and the policy commitment for Bob; DecapsulateFrags succeeds
+// for any cfrags satisfying the policy.
+func KfragsGenPolicy(privAlice *keys.PrivateKey, bobPub *keys.PublicKey, policy *kfrag.Policy) ([][]*kfrag.KFrag, *kfrag.PolicyCommitment, error) {
+	return kfrag.RkgenPolicy(privAlice, bobPub, policy)
+}
+
+// KfragsGenPolicyWithRand is KfragsGenPolicy reading randomness from random, nil means crypto/rand.
+func KfragsGenPolicyWithRand(random io.Reader, privAlice *keys.PrivateKey, bobPub *keys.PublicKey, policy *kfrag.Policy) ([][]*kfrag.KFrag, *kfrag.PolicyCommitment, error) {
+	return kfrag.RkgenPolicyWithRand(random, privAlice, bobPub, policy)
+}
+
+// gateNode is a gate of the policy tree rebuilt from the policy commitment, with
+// the cfrags whose kfrags belong to it. A flat kfrag set is a root gate with
+// leaves only.
+type gateNode struct {
+	gate     *kfrag.GateCommitment
+	path     []kfrag.PathStep
+	leaves   []int
+	children map[int]*gateNode
+}
+
+// buildPolicyTree rebuilds the gates of pc, which must have been verified, and
+// sorts the cfrags into them by path, checking that they belong to pc and carry
+// distinct Ids.
+func buildPolicyTree(pc *kfrag.PolicyCommitment, cfrags []*cfrag.CFrag) (*gateNode, error) {
+	nodes := make(map[string]*gateNode, len(pc.Gates))
+	var root *gateNode
+	for _, gate := range pc.Gates {
+		node := &gateNode{gate: gate, path: gate.Path, children: map[int]*gateNode{}}
+		nodes[string(kfrag.MarshalPath(gate.Path))] = node
+		if len(gate.Path) == 0 {
+			root = node
+			continue
+		}
+		parent := nodes[string(kfrag.MarshalPath(gate.Path[:len(gate.Path)-1]))]
+		parent.children[gate.Path[len(gate.Path)-1].Position] = node
+	}
+
+	commitment := pc.Digest()
+	seen := make(map[string]bool, len(cfrags))
+	for i, cfrag := range cfrags {
+		if !cfrag.XA.IsEqual(pc.XA) || !bytes.Equal(cfrag.Commitment, commitment) {
+			return nil, &ErrInvalidCFrag{Id: cfrag.Id, Reason: "cfrag comes from a different kfrag set"}
+		}
+		id := string(cfrag.Id.Bytes())
+		if seen[id] {
+			return nil, &ErrInvalidCFrag{Id: cfrag.Id, Reason: "duplicate cfrag"}
+		}
+		seen[id] = true
+
+		node, ok := nodes[string(kfrag.MarshalPath(cfrag.Path))]
+		if !ok {
+			return nil, &ErrInvalidCFrag{Id: cfrag.Id, Reason: "cfrag path is not a gate of the policy"}
+		}
+		node.leaves = append(node.leaves, i)
+	}
+	return root, nil
+}
+
+// combine returns the coefficient of each cfrag that interpolates the gate's
+// secret, or nil if the cfrags do not satisfy the gate. S are the cfrags' share
+// indices. Every share, U of the leaves and the secrets of the sub gates, must lie
+// on the gate's committed polynomial, and the shares used must interpolate its
+// committed secret. D is only needed for the indices of sub gates; without it, as
+// when checking a hop, n must have none.
+func (n *gateNode) combine(cfrags []*cfrag.CFrag, S []*curvebn.Scalar, D *curvebn.Scalar) (map[int]*curvebn.Scalar, error) {
+	if D == nil && len(n.children) > 0 {
+		return nil, fmt.Errorf("%w: sub gates need the share index key", ErrInvalidCapsule)
+	}
+	if err := n.verifyShares(cfrags, S, D); err != nil {
+		return nil, err
+	}
+	var xs []*curvebn.Scalar
+	var us []*point.Point
+	var members []map[int]*curvebn.Scalar
+	positions := make([]int, 0, len(n.children))
+	for position := range n.children {
+		positions = append(positions, position)
+	}
+	sort.Ints(positions)
+	for _, position := range positions {
+		child := n.children[position]
+		coeffs, err := child.combine(cfrags, S, D)
+		if err != nil {
+			return nil, err
+		}
+		if coeffs == nil {
+			continue
+		}
+		xs = append(xs, kfrag.GateIndex(D, child.path))
+		us = append(us, child.gate.Secret())
+		members = append(members, coeffs)
+	}
+	if len(xs)+len(n.leaves) < n.gate.Threshold {
+		return nil, nil
+	}
+	for _, i := range n.leaves {
+		xs = append(xs, S[i])
+		us = append(us, cfrags[i].U)
+		members = append(members, map[int]*curvebn.Scalar{i: curvebn.NewScalar(big.NewInt(1))})
+	}
+
+	lambdas, err := curvebn.LagrangeCoefficients(xs)
+	if err != nil {
+		return nil, err
+	}
+	scalars := make([][]byte, len(lambdas))
+	for j, lambda := range lambdas {
+		scalars[j] = lambda.Bytes()
+	}
+	secret, err := point.MultiMulBytes(us, scalars)
+	if err != nil {
+		return nil, err
+	}
+	if !secret.IsEqual(n.gate.Secret()) {
+		return nil, &ErrInvalidCFrag{Reason: "cfrags do not interpolate the committed secret"}
+	}
+
+	coeffs := make(map[int]*curvebn.Scalar)
+	for j, member := range members {
+		for i, c := range member {
+			coeffs[i] = lambdas[j].Mul(c)
+		}
+	}
+	return coeffs, nil
+}
+
+// verifyShares checks the Feldman commitments of n's shares: the secret of every
+// sub gate must lie on the polynomial pc commits n to, and the U of every leaf on
+// the one its cfrags carry, which after a refresh must be the same for all leaves.
+func (n *gateNode) verifyShares(cfrags []*cfrag.CFrag, S []*curvebn.Scalar, D *curvebn.Scalar) error {
+	for _, child := range n.children {
+		if err := kfrag.VerifyShare(n.gate.Coefficients, kfrag.GateIndex(D, child.path), child.gate.Secret()); err != nil {
+			return fmt.Errorf("%w: sub gate is not on the committed polynomial", ErrInvalidCapsule)
+		}
+	}
+	var committed []*point.Point
+	for _, i := range n.leaves {
+		coefficients, err := kfrag.GateCoefficients(n.gate, cfrags[i].Coefficients)
+		if err != nil {
+			return &ErrInvalidCFrag{Id: cfrags[i].Id, Reason: "cfrag coefficients do not match its gate"}
+		}
+		if committed == nil {
+			committed = coefficients
+		} else if !equalPoints(committed, coefficients) {
+			return &ErrInvalidCFrag{Id: cfrags[i].Id, Reason: "cfrags come from different refresh rounds"}
+		}
+		if err := kfrag.VerifyShare(coefficients, S[i], cfrags[i].U); err != nil {
+			return &ErrInvalidCFrag{Id: cfrags[i].Id, Reason: "cfrag is not on the committed polynomial"}
+		}
+	}
+	return nil
+}
+
+// equalPoints reports whether a and b hold the same points in the same order.
+func equalPoints(a, b []*point.Point) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].IsEqual(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// shareIndices returns the share index of every cfrag. Bob recomputes them from
+// D rather than trusting the ones in the kfrags, so a proxy cannot pick its own.
+func shareIndices(cfrags []*cfrag.CFrag, D *curvebn.Scalar) []*curvebn.Scalar {
+	S := make([]*curvebn.Scalar, len(cfrags))
+	for i, cfrag := range cfrags {
+		S[i] = kfrag.ShareIndex(cfrag.Id, D)
+	}
+	return S
+}
+
+// thresholdError reports cfrags that do not satisfy the root gate.
+func thresholdError(root *gateNode, cfrags []*cfrag.CFrag) error {
+	if len(root.children) == 0 {
+		return fmt.Errorf("%w: have %d cfrags, need %d", ErrThresholdNotMet, len(cfrags), root.gate.Threshold)
+	}
+	return fmt.Errorf("%w: cfrags do not satisfy the policy", ErrThresholdNotMet)
+}
@@ -1,8 +1,8 @@
 package prencrypt
 
 import (
-	"errors"
-	"math/big"
+	"fmt"
+	"io"
 
 	"github.com/hongyuefan/prencrypt/capsule"
 	"github.com/hongyuefan/prencrypt/cfrag"
@@ -10,73 +10,113 @@ import (
 	"github.com/hongyuefan/prencrypt/keys"
 	"github.com/hongyuefan/prencrypt/kfrag"
 	"github.com/hongyuefan/prencrypt/point"
-	"github.com/hongyuefan/prencrypt/util"
 )
 
 func Encapsulate(alicePub *keys.PublicKey) ([]byte, *capsule.Capsule, error) {
-	if alicePub == nil {
-		return nil, nil, errors.New("publickey is nil")
+	return EncapsulateWithRand(nil, alicePub)
+}
+
+// EncapsulateWithRand is Encapsulate reading randomness from random, nil means crypto/rand.
+func EncapsulateWithRand(random io.Reader, alicePub *keys.PublicKey) ([]byte, *capsule.Capsule, error) {
+	if err := alicePub.Validate(); err != nil {
+		return nil, nil, err
 	}
-	priv_r, err := keys.GenerateKey()
+	cap, ru, err := newCapsule(random)
 	if err != nil {
 		return nil, nil, err
 	}
-	priv_u, err := keys.GenerateKey()
+	sharedKey, err := alicePub.Point.MulBytes(ru.Bytes()).KDF()
 	if err != nil {
 		return nil, nil, err
 	}
+	return sharedKey, cap, nil
+}
 
-	h, err := curvebn.PointsHash2CurvBN(priv_r.PublicKey.Point, priv_u.PublicKey.Point)
+// newCapsule draws r, u and returns the capsule rG, uG, u+r*H(rG, uG) with r+u,
+// the key of a recipient with public key P being KDF((r+u)*P).
+func newCapsule(random io.Reader) (*capsule.Capsule, *curvebn.Scalar, error) {
+	priv_r, err := keys.GenerateKeyWithRand(random)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	s := priv_u.Add(priv_r.Mul(h.Int()))
-
-	sharedKey, err := alicePub.Point.Mul(priv_r.Add(priv_u.Int())).KDF()
+	priv_u, err := keys.GenerateKeyWithRand(random)
 	if err != nil {
 		return nil, nil, err
 	}
-	return sharedKey, &capsule.Capsule{E: priv_r.PublicKey.Point, V: priv_u.PublicKey.Point, S: s}, nil
+
+	h := capsule.Hash(priv_r.PublicKey.Point, priv_u.PublicKey.Point)
+
+	s := priv_u.Scalar().Add(priv_r.Scalar().Mul(h))
+
+	return &capsule.Capsule{E: priv_r.PublicKey.Point, V: priv_u.PublicKey.Point, S: s}, priv_r.Scalar().Add(priv_u.Scalar()), nil
 }
 
 func DecapsulateOriginal(alicePriv *keys.PrivateKey, capsule *capsule.Capsule) ([]byte, error) {
-	if !capsule.Verify() {
-		return nil, errors.New("capsule verification failed")
+	if alicePriv == nil {
+		return nil, fmt.Errorf("%w: private key is nil", ErrInvalidKey)
+	}
+	if err := verifyCapsule(capsule); err != nil {
+		return nil, err
 	}
-	return capsule.E.Add(capsule.V).Mul(alicePriv.Int()).KDF()
+	return capsule.E.Add(capsule.V).MulBytes(alicePriv.Scalar().Bytes()).KDF()
 }
 
-func KfragsGen(privAlice *keys.PrivateKey, bobPub *keys.PublicKey, N, t int) ([]*kfrag.KFrag, error) {
+// KfragsGen issues N kfrags from Alice to Bob, any t of which re-encrypt a capsule.
+// The kfrags go to the proxies and the policy commitment to Bob, who needs it for
+// DecapsulateFrags.
+func KfragsGen(privAlice *keys.PrivateKey, bobPub *keys.PublicKey, N, t int) ([]*kfrag.KFrag, *kfrag.PolicyCommitment, error) {
 	return kfrag.Rkgen(privAlice, bobPub, N, t)
 }
 
+// KfragsGenWithRand is KfragsGen reading randomness from random, nil means crypto/rand.
+func KfragsGenWithRand(random io.Reader, privAlice *keys.PrivateKey, bobPub *keys.PublicKey, N, t int) ([]*kfrag.KFrag, *kfrag.PolicyCommitment, error) {
+	return kfrag.RkgenWithRand(random, privAlice, bobPub, N, t)
+}
+
 func ReEncapsulate(kfrag *kfrag.KFrag, capsule *capsule.Capsule, aux []byte) (*cfrag.CFrag, error) {
-	if kfrag == nil || capsule == nil {
-		return nil, errors.New("params is nil")
+	return ReEncapsulateWithRand(nil, kfrag, capsule, aux)
+}
+
+// ReEncapsulateWithRand is ReEncapsulate reading randomness from random, nil means crypto/rand.
+func ReEncapsulateWithRand(random io.Reader, kfrag *kfrag.KFrag, capsule *capsule.Capsule, aux []byte) (*cfrag.CFrag, error) {
+	if err := kfrag.Validate(); err != nil {
+		return nil, err
 	}
-	if !capsule.Verify() {
-		return nil, errors.New("capsule verification failed")
+	if err := verifyCapsule(capsule); err != nil {
+		return nil, err
 	}
-	cfrg := new(cfrag.CFrag)
-	cfrg.E1 = capsule.E.Mul(kfrag.Rk.Int())
-	cfrg.V1 = capsule.V.Mul(kfrag.Rk.Int())
-	cfrg.Id = kfrag.Id
-	cfrg.XA = kfrag.XA
-
-	t, err := keys.GenerateKey()
+	t, err := curvebn.RandomScalarWithRand(random)
 	if err != nil {
 		return nil, err
 	}
-
-	E2 := capsule.E.Mul(t.Int())
-	V2 := capsule.V.Mul(t.Int())
-	U2 := point.UPoint().Mul(t.Int())
-
-	h, err := curvebn.BytesHash2CurvBN(util.AppendByt(capsule.E.Marshal(), cfrg.E1.Marshal(), E2.Marshal(), capsule.V.Marshal(), cfrg.V1.Marshal(), V2.Marshal(), point.UPoint().Marshal(), kfrag.U.Marshal(), U2.Marshal(), aux))
+	cfrg, err := reEncapsulate(kfrag, point.NewScalarMul(kfrag.Rk.Bytes()), capsule, aux, t)
 	if err != nil {
 		return nil, err
 	}
+	if !cfrg.Verify(capsule.E, capsule.V) {
+		return nil, &ErrInvalidCFrag{Id: kfrag.Id, Reason: "proof verification failed"}
+	}
+	return cfrg, nil
+}
+
+// reEncapsulate computes the cfrag and its proof for an already verified capsule,
+// rk multiplies by kfrag.Rk and t is the random proof nonce.
+func reEncapsulate(kfrag *kfrag.KFrag, rk *point.ScalarMul, capsule *capsule.Capsule, aux []byte, t *curvebn.Scalar) (*cfrag.CFrag, error) {
+	cfrg := new(cfrag.CFrag)
+	cfrg.E1 = rk.Mul(capsule.E)
+	cfrg.V1 = rk.Mul(capsule.V)
+	cfrg.Id = kfrag.Id
+	cfrg.XA = kfrag.XA
+	cfrg.Commitment = kfrag.Commitment
+	cfrg.U = kfrag.U
+	cfrg.Path = kfrag.Path
+	cfrg.Coefficients = kfrag.Coefficients
+
+	E2 := capsule.E.MulBytes(t.Bytes())
+	V2 := capsule.V.MulBytes(t.Bytes())
+	U2 := point.UMulBytes(t.Bytes())
+
+	h := cfrag.ProofChallenge(capsule.E, cfrg.E1, E2, capsule.V, cfrg.V1, V2, kfrag.U, U2, aux)
 	cfrg.Pi = &cfrag.Proof{
 		E2:  E2,
 		V2:  V2,
@@ -84,61 +124,98 @@ func ReEncapsulate(kfrag *kfrag.KFrag, capsule *capsule.Capsule, aux []byte) (*c
 		U1:  kfrag.U,
 		Z1:  kfrag.Z1,
 		Z2:  kfrag.Z2,
-		Rol: new(big.Int).Add(t.Int(), new(big.Int).Mul(h.Int(), kfrag.Rk.Int())),
+		Rol: t.Add(h.Mul(kfrag.Rk)),
 		Aux: aux,
 	}
-	if !cfrg.Verify(capsule.E, capsule.V) {
-		return nil, errors.New("cfrag verify failed")
-	}
 	return cfrg, nil
 }
 
-func DecapsulateFrags(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, cfrags []*cfrag.CFrag) ([]byte, error) {
-
-	if privBob == nil || pubAlice == nil || len(cfrags) < 1 {
-		return nil, errors.New("params not right")
+// DecapsulateFrags opens capsule with Bob's private key and the cfrags the proxies
+// re-encrypted it into. pc is the policy commitment Alice issued with the kfrags;
+// every cfrag's proof must verify against capsule and the cfrags must belong to pc
+// and satisfy its thresholds.
+func DecapsulateFrags(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, capsule *capsule.Capsule, cfrags []*cfrag.CFrag) ([]byte, error) {
+	if err := verifyCapsule(capsule); err != nil {
+		return nil, err
 	}
+	return decapsulateFrags(privBob, pubAlice, pc, capsule.E, capsule.V, cfrags)
+}
 
-	pXA := cfrags[0].XA
-
-	D, err := curvebn.PointsHash2CurvBN(pubAlice.Point, privBob.PublicKey.Point, pubAlice.Point.Mul(privBob.Int()))
+// decapsulateFrags is DecapsulateFrags for cfrags re-encrypting the capsule points E, V.
+func decapsulateFrags(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, E, V *point.Point, cfrags []*cfrag.CFrag) ([]byte, error) {
+	lambdas, _, dS, err := combineCFrags(privBob, pubAlice, pc, E, V, cfrags)
 	if err != nil {
 		return nil, err
 	}
 
-	var S []*big.Int
-	for _, cfrag := range cfrags {
-		s, err := curvebn.BytesHash2CurvBN(util.AppendByt(cfrag.Id.Bytes(), D.P))
-		if err != nil {
-			return nil, err
+	// (sum(lambda_i*E1_i) + sum(lambda_i*V1_i)) * d = sum(lambda_i*d * (E1_i + V1_i)),
+	// over the cfrags the policy used
+	summands := make([]*point.Point, 0, len(lambdas))
+	scalars := make([][]byte, 0, len(lambdas))
+	for index, cfrag := range cfrags {
+		lambda, ok := lambdas[index]
+		if !ok {
+			continue
 		}
-		S = append(S, s.Int())
+		summands = append(summands, cfrag.E1.Add(cfrag.V1))
+		scalars = append(scalars, lambda.Mul(dS).Bytes())
 	}
+	sum, err := point.MultiMulBytes(summands, scalars)
+	if err != nil {
+		return nil, err
+	}
+	return sum.KDF()
+}
 
-	var e_summands []*point.Point
-	var v_summands []*point.Point
+// combineCFrags checks pc and the cfrags of the capsule points E, V and returns the
+// coefficient of each cfrag used to interpolate the re-encrypted capsule, the
+// cfrags' share indices and Bob's scalar d for XA.
+func combineCFrags(privBob *keys.PrivateKey, pubAlice *keys.PublicKey, pc *kfrag.PolicyCommitment, E, V *point.Point, cfrags []*cfrag.CFrag) (map[int]*curvebn.Scalar, []*curvebn.Scalar, *curvebn.Scalar, error) {
 
-	for index := range S {
-		numerator, denominator, err := util.LambdaS(index, S)
-		if err != nil {
-			return nil, err
-		}
-		lambS := new(big.Int).Mul(numerator, new(big.Int).ModInverse(denominator, util.Curve.Params().N))
-		e_summands = append(e_summands, cfrags[index].E1.Mul(lambS))
-		v_summands = append(v_summands, cfrags[index].V1.Mul(lambS))
+	if privBob == nil {
+		return nil, nil, nil, fmt.Errorf("%w: private key is nil", ErrInvalidKey)
+	}
+	if len(cfrags) < 1 {
+		return nil, nil, nil, fmt.Errorf("%w: no cfrags", ErrThresholdNotMet)
+	}
+	if err := pc.Verify(pubAlice, privBob.PublicKey); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := verifyCFrags(E, V, cfrags); err != nil {
+		return nil, nil, nil, err
 	}
 
-	E := e_summands[0]
-	V := v_summands[0]
-	for index := 1; index < len(cfrags); index++ {
-		E = E.Add(e_summands[index])
-		V = V.Add(v_summands[index])
+	root, err := buildPolicyTree(pc, cfrags)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	d, err := curvebn.PointsHash2CurvBN(pXA, privBob.PublicKey.Point, pXA.Mul(privBob.Int()))
+	pXA := pc.XA
+
+	D := kfrag.ShareIndexKey(pubAlice.Point, privBob.PublicKey.Point, pubAlice.Point.MulBytes(privBob.Scalar().Bytes()))
+	S := shareIndices(cfrags, D)
+	lambdas, err := root.combine(cfrags, S, D)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
+	if lambdas == nil {
+		return nil, nil, nil, thresholdError(root, cfrags)
+	}
+
+	d := kfrag.PrecursorKey(pXA, privBob.PublicKey.Point, pXA.MulBytes(privBob.Scalar().Bytes()))
+	return lambdas, S, d, nil
+}
 
-	return E.Add(V).Mul(d.Convert2CanInverseCurvBN().Int()).KDF()
+// verifyCFrags checks that every cfrag is complete and its proof verifies against
+// the capsule points E, V, so no forged E1, V1 reach the interpolation.
+func verifyCFrags(E, V *point.Point, cfrags []*cfrag.CFrag) error {
+	for _, cfrag := range cfrags {
+		if err := cfrag.Validate(); err != nil {
+			return err
+		}
+		if !cfrag.Verify(E, V) {
+			return &ErrInvalidCFrag{Id: cfrag.Id, Reason: "proof verification failed"}
+		}
+	}
+	return nil
 }
@@ -2,11 +2,18 @@ package prencrypt
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"testing"
 
+	"github.com/hongyuefan/prencrypt/capsule"
 	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/curvebn"
 	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/point"
 	"github.com/hongyuefan/prencrypt/symcrypt"
 	"github.com/stretchr/testify/assert"
 )
@@ -38,7 +45,7 @@ func oneceOp() error {
 	}
 
 	// alice authrize for bob，shamir secret share scheme
-	kFrags, err := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	kFrags, pc, err := KfragsGen(privAlice, privBob.PublicKey, N, T)
 	if err != nil {
 		return err
 	}
@@ -64,7 +71,7 @@ func oneceOp() error {
 	}
 
 	//bob use his privatekey decapsule sharekey
-	shareKeyBob, err := DecapsulateFrags(privBob, privAlice.PublicKey, cFrags)
+	shareKeyBob, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsule, cFrags)
 	if err != nil {
 		return err
 	}
@@ -88,3 +95,922 @@ func TestBench(t *testing.T) {
 		return
 	}
 }
+
+func TestEncryptBindsCapsule(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+
+	cipherText, capsule, err := Encrypt(privAlice.PublicKey, []byte("hello world"), []byte("label"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	plainText, err := DecryptOriginal(privAlice, capsule, cipherText, []byte("label"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []byte("hello world"), plainText)
+
+	kFrags, pc, err := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var cFrags []*cfrag.CFrag
+	for i := 0; i < T; i++ {
+		cfrg, err := ReEncapsulate(kFrags[i], capsule, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		cFrags = append(cFrags, cfrg)
+	}
+	plainText, err = DecryptReencrypted(privBob, privAlice.PublicKey, pc, capsule, cFrags, cipherText, []byte("label"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []byte("hello world"), plainText)
+
+	_, err = DecryptOriginal(privAlice, capsule, cipherText, []byte("other"))
+	assert.Error(t, err)
+
+	_, otherCapsule, err := Encrypt(privAlice.PublicKey, []byte("hello world"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = DecryptOriginal(privAlice, otherCapsule, cipherText, []byte("label"))
+	assert.Error(t, err)
+}
+
+func TestMessageKit(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+
+	mk := NewMessageKit()
+	mk.Label = []byte("policy")
+	if !assert.NoError(t, mk.Encrypt(privAlice.PublicKey, []byte("hello world"))) {
+		return
+	}
+
+	fromBytes := NewMessageKit()
+	if !assert.NoError(t, fromBytes.Unmarshal(marshalKit(t, mk))) {
+		return
+	}
+	assert.Equal(t, marshalKit(t, mk), marshalKit(t, fromBytes))
+	hexKit, err := mk.Hex()
+	assert.NoError(t, err)
+	fromHex := NewMessageKit()
+	assert.NoError(t, fromHex.FromHex(hexKit))
+
+	byt, err := json.Marshal(mk)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fromJSON := NewMessageKit()
+	if !assert.NoError(t, json.Unmarshal(byt, fromJSON)) {
+		return
+	}
+	assert.Equal(t, marshalKit(t, mk), marshalKit(t, fromJSON))
+
+	plainText, err := fromJSON.Decrypt(privAlice)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), plainText)
+
+	kFrags, pc, err := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var cFrags []*cfrag.CFrag
+	for i := 0; i < T; i++ {
+		cfrg, err := ReEncapsulate(kFrags[i], fromBytes.Capsule, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		cFrags = append(cFrags, cfrg)
+	}
+	plainText, err = fromBytes.DecryptReencrypted(privBob, privAlice.PublicKey, pc, cFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), plainText)
+
+	fromBytes.Label = []byte("other")
+	_, err = fromBytes.Decrypt(privAlice)
+	assert.Error(t, err)
+
+	// fields too long for their length prefix are rejected, not truncated
+	fromBytes.Label = make([]byte, 0x10000)
+	_, err = fromBytes.Marshal()
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+	_, err = fromBytes.Hex()
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+	assert.True(t, errors.Is(fromBytes.Encrypt(privAlice.PublicKey, []byte("hello world")), ErrInvalidArgument))
+}
+
+func marshalKit(t *testing.T, mk *MessageKit) []byte {
+	byt, err := mk.Marshal()
+	assert.NoError(t, err)
+	return byt
+}
+
+func TestEmptyPlainText(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+
+	cipherText, capsule, err := Encrypt(privAlice.PublicKey, nil, []byte("label"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	plainText, err := DecryptOriginal(privAlice, capsule, cipherText, []byte("label"))
+	assert.NoError(t, err)
+	assert.Empty(t, plainText)
+
+	mk := NewMessageKit()
+	if !assert.NoError(t, mk.Encrypt(privAlice.PublicKey, []byte{})) {
+		return
+	}
+	fromBytes := NewMessageKit()
+	if !assert.NoError(t, fromBytes.Unmarshal(marshalKit(t, mk))) {
+		return
+	}
+	plainText, err = fromBytes.Decrypt(privAlice)
+	assert.NoError(t, err)
+	assert.Empty(t, plainText)
+}
+
+func TestSignedMessageKit(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privSender, _ := keys.GenerateKey()
+	privOther, _ := keys.GenerateKey()
+
+	mk := NewMessageKit()
+	if !assert.NoError(t, mk.EncryptSigned(privAlice.PublicKey, privSender, []byte("hello world"))) {
+		return
+	}
+	kit := NewMessageKit()
+	if !assert.NoError(t, kit.Unmarshal(marshalKit(t, mk))) {
+		return
+	}
+	plainText, err := kit.DecryptFrom(privAlice, privSender.PublicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), plainText)
+
+	_, err = kit.DecryptFrom(privAlice, privOther.PublicKey)
+	assert.Error(t, err)
+
+	// swapping in a different sender key without its signature is detected
+	kit.SenderKey = privOther.PublicKey
+	_, err = kit.DecryptFrom(privAlice, privOther.PublicKey)
+	assert.Error(t, err)
+
+	// re-signing someone else's kit only passes as coming from the new signer
+	resigned := NewMessageKit()
+	if !assert.NoError(t, resigned.Unmarshal(marshalKit(t, mk))) {
+		return
+	}
+	if !assert.NoError(t, resigned.Sign(privOther)) {
+		return
+	}
+	_, err = resigned.DecryptFrom(privAlice, privSender.PublicKey)
+	assert.Error(t, err)
+	plainText, err = resigned.DecryptFrom(privAlice, privOther.PublicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), plainText)
+
+	unsigned := NewMessageKit()
+	assert.NoError(t, unsigned.Encrypt(privAlice.PublicKey, []byte("hello world")))
+	_, err = unsigned.DecryptFrom(privAlice, privSender.PublicKey)
+	assert.Error(t, err)
+}
+
+func BenchmarkReEncapsulate(b *testing.B) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	_, capsule, _ := Encapsulate(privAlice.PublicKey)
+	kFrags, _, _ := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReEncapsulate(kFrags[0], capsule, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecapsulateFrags(b *testing.B) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	_, capsule, _ := Encapsulate(privAlice.PublicKey)
+	kFrags, pc, _ := KfragsGen(privAlice, privBob.PublicKey, 10, 10)
+	var cFrags []*cfrag.CFrag
+	for _, kFrag := range kFrags {
+		cfrg, _ := ReEncapsulate(kFrag, capsule, nil)
+		cFrags = append(cFrags, cfrg)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsule, cFrags); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBatchVerify(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	kFrags, _, err := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var capsules []*capsule.Capsule
+	var cFrags []*cfrag.CFrag
+	for i := 0; i < 5; i++ {
+		_, capsule, err := Encapsulate(privAlice.PublicKey)
+		if !assert.NoError(t, err) {
+			return
+		}
+		cfrg, err := ReEncapsulate(kFrags[i%N], capsule, []byte("aux"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		capsules = append(capsules, capsule)
+		cFrags = append(cFrags, cfrg)
+	}
+
+	results, err := cfrag.BatchVerify(capsules, cFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true, true, true}, results)
+
+	// the weights can come from a seeded reader
+	assert.Equal(t, []bool{true, true, true, true, true}, capsule.BatchVerifyWithRand(rand.New(rand.NewSource(1)), capsules))
+	results, err = cfrag.BatchVerifyWithRand(rand.New(rand.NewSource(1)), capsules, cFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true, true, true}, results)
+
+	// cfrags and capsules received over the wire carry everything the batch needs
+	var decodedCapsules []*capsule.Capsule
+	var decodedCFrags []*cfrag.CFrag
+	for i := range cFrags {
+		decodedCapsule := capsule.NewCapsule()
+		if !assert.NoError(t, decodedCapsule.Unmarshal(capsules[i].Marshal())) {
+			return
+		}
+		decodedCFrag := cfrag.NewCFrag()
+		if !assert.NoError(t, decodedCFrag.Unmarshal(cFrags[i].Marshal())) {
+			return
+		}
+		decodedCapsules = append(decodedCapsules, decodedCapsule)
+		decodedCFrags = append(decodedCFrags, decodedCFrag)
+	}
+	results, err = cfrag.BatchVerify(decodedCapsules, decodedCFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true, true, true}, results)
+	decodedCFrags[2].Pi.Aux = []byte("other aux")
+	results, err = cfrag.BatchVerify(decodedCapsules, decodedCFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, false, true, true}, results)
+
+	cFrags[3].Pi.Rol = cFrags[3].Pi.Rol.Add(cFrags[3].Pi.Rol)
+	results, err = cfrag.BatchVerify(capsules, cFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true, false, true}, results)
+}
+
+func TestReEncapsulateBatch(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	kFrags, pc, err := KfragsGen(privAlice, privBob.PublicKey, 2, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var sharedKeys [][]byte
+	var capsules []*capsule.Capsule
+	for i := 0; i < 8; i++ {
+		key, capsule, err := Encapsulate(privAlice.PublicKey)
+		if !assert.NoError(t, err) {
+			return
+		}
+		sharedKeys = append(sharedKeys, key)
+		capsules = append(capsules, capsule)
+	}
+	// a capsule that fails verification only affects its own slot
+	capsules[5].S = capsules[5].S.Add(capsules[5].S)
+
+	cFrags, errs := ReEncapsulateBatch(kFrags[0], capsules, nil)
+	for i := range capsules {
+		if i == 5 {
+			assert.Error(t, errs[i])
+			assert.Nil(t, cFrags[i])
+			continue
+		}
+		if !assert.NoError(t, errs[i]) {
+			return
+		}
+		key, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsules[i], []*cfrag.CFrag{cFrags[i]})
+		assert.NoError(t, err)
+		assert.Equal(t, sharedKeys[i], key)
+	}
+}
+
+func TestWithRandDeterministic(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+
+	run := func() ([]byte, *capsule.Capsule, []*kfrag.KFrag) {
+		random := rand.New(rand.NewSource(1))
+		cipherText, capsule, err := EncryptWithRand(random, privAlice.PublicKey, []byte("deterministic"), nil)
+		assert.NoError(t, err)
+		kFrags, _, err := KfragsGenWithRand(random, privAlice, privBob.PublicKey, N, T)
+		assert.NoError(t, err)
+		return cipherText, capsule, kFrags
+	}
+	ct1, cap1, kf1 := run()
+	ct2, cap2, kf2 := run()
+	assert.Equal(t, ct1, ct2)
+	assert.Equal(t, cap1.Marshal(), cap2.Marshal())
+	for i := range kf1 {
+		assert.Equal(t, kf1[i].Marshal(), kf2[i].Marshal())
+	}
+
+	plainText, err := DecryptOriginal(privAlice, cap1, ct1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("deterministic"), plainText)
+}
+
+func TestRejectInvalidPoints(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+
+	_, _, err := Encapsulate(&keys.PublicKey{Point: point.Identity()})
+	assert.Error(t, err)
+	_, _, err = KfragsGen(privAlice, &keys.PublicKey{Point: point.Identity()}, N, T)
+	assert.Error(t, err)
+
+	_, capsule, _ := Encapsulate(privAlice.PublicKey)
+	kFrags, pc, _ := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	var cFrags []*cfrag.CFrag
+	for _, kFrag := range kFrags[:T] {
+		cFrag, err := ReEncapsulate(kFrag, capsule, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		cFrags = append(cFrags, cFrag)
+	}
+
+	// a cfrag whose XA belongs to another kfrag set is refused
+	other, _, _ := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	forged := *cFrags[1]
+	forged.XA = other[0].XA
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsule, []*cfrag.CFrag{cFrags[0], &forged})
+	assert.Error(t, err)
+
+	forged.XA = point.Identity()
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsule, []*cfrag.CFrag{cFrags[0], &forged})
+	assert.Error(t, err)
+}
+
+func TestDecapsulateFragsChecksProofs(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+
+	sharedKey, capsule, _ := Encapsulate(privAlice.PublicKey)
+	_, otherCapsule, _ := Encapsulate(privAlice.PublicKey)
+	kFrags, pc, _ := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	var cFrags []*cfrag.CFrag
+	for _, kFrag := range kFrags[:T] {
+		cFrag, err := ReEncapsulate(kFrag, capsule, []byte("aux"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		decoded := cfrag.NewCFrag()
+		if !assert.NoError(t, decoded.Unmarshal(cFrag.Marshal())) {
+			return
+		}
+		assert.Equal(t, cFrag, decoded)
+		cFrags = append(cFrags, decoded)
+	}
+	key, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsule, cFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, sharedKey, key)
+
+	// cfrags of another capsule are refused before they are combined
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, otherCapsule, cFrags)
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+
+	tampered := *cFrags[1]
+	tampered.E1 = tampered.E1.Add(tampered.V1)
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsule, []*cfrag.CFrag{cFrags[0], &tampered})
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+
+	unproven := *cFrags[1]
+	unproven.Pi = nil
+	assert.True(t, errors.Is(unproven.Validate(), &ErrInvalidCFrag{}))
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsule, []*cfrag.CFrag{cFrags[0], &unproven})
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+
+	// the proof must be about the U Bob checks against pc
+	otherU := *cFrags[1]
+	otherU.U = cFrags[0].U
+	assert.True(t, errors.Is(otherU.Validate(), &ErrInvalidCFrag{}))
+}
+
+func TestErrors(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+
+	cipherText, cap, err := Encrypt(privAlice.PublicKey, []byte("hello"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, errors.Is(capsule.NewCapsule().Unmarshal([]byte{1, 2, 3}), ErrMalformedEncoding))
+
+	tampered := *cap
+	tampered.S = cap.S.Add(cap.S)
+	_, err = DecapsulateOriginal(privAlice, &tampered)
+	assert.True(t, errors.Is(err, ErrInvalidCapsule))
+
+	cipherText[len(cipherText)-1] ^= 1
+	_, err = DecryptOriginal(privAlice, cap, cipherText, nil)
+	assert.True(t, errors.Is(err, ErrDecryptionFailed))
+
+	kFrags, pc, _ := KfragsGen(privAlice, privBob.PublicKey, N, T)
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, nil)
+	assert.True(t, errors.Is(err, ErrThresholdNotMet))
+
+	_, _, err = Encapsulate(&keys.PublicKey{Point: point.Identity()})
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+	_, err = keys.NewPublicKeyFromBytes(point.Identity().Marshal())
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+	_, err = DecapsulateOriginal(nil, cap)
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+	_, _, err = KfragsGen(privAlice, privBob.PublicKey, 2, 3)
+	assert.True(t, errors.Is(err, ErrInvalidKFrag))
+	_, err = cfrag.BatchVerify([]*capsule.Capsule{cap}, nil)
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+
+	cFrag, _ := ReEncapsulate(kFrags[0], cap, nil)
+	cFrag.Pi.Rol = cFrag.Pi.Rol.Add(cFrag.Pi.Rol)
+	assert.False(t, cFrag.Verify(cap.E, cap.V))
+	cFrag.E1 = point.Identity()
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, []*cfrag.CFrag{cFrag})
+	var invalid *ErrInvalidCFrag
+	if assert.True(t, errors.As(err, &invalid)) {
+		assert.True(t, invalid.Id.IsEqual(kFrags[0].Id))
+	}
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+}
+
+func TestDecapsulateFragsThreshold(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	sharedKey, cap, _ := Encapsulate(privAlice.PublicKey)
+	kFrags, pc, err := KfragsGen(privAlice, privBob.PublicKey, 5, 3)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var cFrags []*cfrag.CFrag
+	for _, kFrag := range kFrags {
+		cFrag, err := ReEncapsulate(kFrag, cap, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		cFrags = append(cFrags, cFrag)
+	}
+
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, cFrags[:2])
+	assert.True(t, errors.Is(err, ErrThresholdNotMet))
+
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, []*cfrag.CFrag{cFrags[0], cFrags[1], cFrags[1]})
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+
+	// cfrags of another sharing with the same threshold are told apart by the commitment
+	other, otherPC, _ := KfragsGen(privAlice, privBob.PublicKey, 5, 3)
+	mixed, _ := ReEncapsulate(other[0], cap, nil)
+	mixed.XA = cFrags[0].XA
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, []*cfrag.CFrag{cFrags[0], cFrags[1], mixed})
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, otherPC, cap, cFrags[2:])
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+
+	// the threshold comes from Alice's signature, not from the proxies
+	lowered := *pc
+	lowered.Gates = []*kfrag.GateCommitment{{Threshold: 2, Coefficients: pc.Gates[0].Coefficients[:2]}}
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, &lowered, cap, cFrags[:2])
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	// a cfrag whose U is not on the committed polynomial at Bob's share index
+	swapped := *cFrags[0]
+	swapped.U = cFrags[1].U
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, []*cfrag.CFrag{&swapped, cFrags[1], cFrags[2]})
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+
+	// a proxy with a share off the polynomial produces a valid proof, but its U
+	// fails the Feldman check, both for the proxy and for Bob
+	forged := *kFrags[0]
+	forged.Rk, _ = curvebn.RandomScalar()
+	forged.U = point.UMulBytes(forged.Rk.Bytes())
+	assert.True(t, errors.Is(forged.VerifyShare(pc), ErrInvalidKFrag))
+	assert.True(t, errors.Is(forged.Verify(privAlice.PublicKey, privBob.PublicKey, pc), ErrInvalidKFrag))
+	offPolynomial, err := ReEncapsulate(&forged, cap, nil)
+	assert.NoError(t, err)
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, []*cfrag.CFrag{offPolynomial, cFrags[1], cFrags[2], cFrags[3]})
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+
+	key, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, cFrags[2:])
+	assert.NoError(t, err)
+	assert.Equal(t, sharedKey, key)
+}
+
+func TestDecapsulateFragsWrongKey(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	privEve, _ := keys.GenerateKey()
+	sharedKey, cap, _ := Encapsulate(privAlice.PublicKey)
+
+	for _, threshold := range []int{1, 3} {
+		kFrags, pc, err := KfragsGen(privAlice, privBob.PublicKey, 3, threshold)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var cFrags []*cfrag.CFrag
+		for _, kFrag := range kFrags {
+			cFrag, err := ReEncapsulate(kFrag, cap, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			cFrags = append(cFrags, cFrag)
+		}
+
+		// the cfrags and public keys alone must not give the key
+		key, err := DecapsulateFrags(privEve, privAlice.PublicKey, pc, cap, cFrags)
+		assert.True(t, err != nil || !bytes.Equal(sharedKey, key), "threshold %d", threshold)
+
+		key, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, cFrags)
+		assert.NoError(t, err)
+		assert.Equal(t, sharedKey, key)
+	}
+}
+
+func TestExtendDelegation(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	sharedKey, cap, _ := Encapsulate(privAlice.PublicKey)
+
+	dl, err := kfrag.NewDelegation(nil, privAlice, privBob.PublicKey, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	kFrags, err := dl.Generate(nil, privAlice, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	sealed, err := dl.Seal(nil, privAlice)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = kfrag.OpenDelegation(privBob, sealed)
+	assert.Error(t, err)
+	restored, err := kfrag.OpenDelegation(privAlice, sealed)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, dl.Commitment(), restored.Commitment())
+	pc, err := restored.PolicyCommitment(nil, privAlice)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, dl.Commitment(), pc.Digest())
+	added, err := restored.Generate(nil, privAlice, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, added[0].Verify(privAlice.PublicKey, privBob.PublicKey, pc))
+
+	// a kfrag issued before sealing combines with one issued after
+	var cFrags []*cfrag.CFrag
+	for _, kFrag := range []*kfrag.KFrag{kFrags[0], added[0]} {
+		cFrag, err := ReEncapsulate(kFrag, cap, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		cFrags = append(cFrags, cFrag)
+	}
+	key, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, cFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, sharedKey, key)
+}
+
+func TestRefreshKFrags(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	sharedKey, cap, _ := Encapsulate(privAlice.PublicKey)
+	kFrags, pc, _ := KfragsGen(privAlice, privBob.PublicKey, 4, 3)
+
+	refreshed, err := kfrag.RefreshAll(nil, pc, kFrags)
+	if !assert.NoError(t, err) {
+		return
+	}
+	reEncapsulate := func(kFrags []*kfrag.KFrag) []*cfrag.CFrag {
+		var cFrags []*cfrag.CFrag
+		for _, kFrag := range kFrags {
+			cFrag, err := ReEncapsulate(kFrag, cap, nil)
+			assert.NoError(t, err)
+			cFrags = append(cFrags, cFrag)
+		}
+		return cFrags
+	}
+	for i, kf := range refreshed {
+		assert.Equal(t, kFrags[i].Commitment, kf.Commitment)
+		assert.False(t, kf.Rk.IsEqual(kFrags[i].Rk))
+		assert.Equal(t, uint32(1), kf.Epoch)
+		// the refreshed share verifies against the refreshed coefficients
+		assert.NoError(t, kf.Verify(privAlice.PublicKey, privBob.PublicKey, pc))
+		decoded := kfrag.NewKFrag()
+		assert.NoError(t, decoded.FromHex(kf.Hex()))
+		assert.Equal(t, kf.Epoch, decoded.Epoch)
+		assert.NoError(t, decoded.Verify(privAlice.PublicKey, privBob.PublicKey, pc))
+	}
+	// nor does the old share verify against them
+	stale := *kFrags[0]
+	stale.Epoch, stale.Coefficients = refreshed[0].Epoch, refreshed[0].Coefficients
+	assert.True(t, errors.Is(stale.Verify(privAlice.PublicKey, privBob.PublicKey, pc), kfrag.ErrInvalidKFrag))
+
+	again, err := kfrag.RefreshAll(nil, pc, refreshed)
+	if !assert.NoError(t, err) {
+		return
+	}
+	key, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, reEncapsulate(again[:3]))
+	assert.NoError(t, err)
+	assert.Equal(t, sharedKey, key)
+
+	key, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, reEncapsulate(refreshed[1:]))
+	assert.NoError(t, err)
+	assert.Equal(t, sharedKey, key)
+
+	// shares from before the refresh do not combine with shares from after it
+	mixed := reEncapsulate([]*kfrag.KFrag{kFrags[0], refreshed[1], refreshed[2]})
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, mixed)
+	assert.Error(t, err)
+
+	// a dealer cannot hand out a share off its committed polynomial
+	dealing, _ := kfrag.NewRefreshDealing(nil, 3, []*curvebn.Scalar{kFrags[0].Share, kFrags[1].Share})
+	dealing[0].Value = dealing[0].Value.Add(dealing[0].Value)
+	_, err = kFrags[0].Refresh(pc, dealing[:1])
+	assert.True(t, errors.Is(err, kfrag.ErrInvalidKFrag))
+	_, err = kFrags[1].Refresh(pc, dealing[1:])
+	assert.NoError(t, err)
+	// nor can a proxy apply a share dealt to another share index
+	_, err = kFrags[0].Refresh(pc, dealing[1:])
+	assert.True(t, errors.Is(err, kfrag.ErrInvalidKFrag))
+	_, err = kfrag.NewRefreshDealing(nil, 0, []*curvebn.Scalar{kFrags[0].Share})
+	assert.True(t, errors.Is(err, kfrag.ErrInvalidKFrag))
+
+	// the epoch has its own bound
+	last := *refreshed[0]
+	last.Epoch = kfrag.MaxEpoch
+	decoded := kfrag.NewKFrag()
+	assert.NoError(t, decoded.FromHex(last.Hex()))
+	assert.Equal(t, uint32(kfrag.MaxEpoch), decoded.Epoch)
+	_, err = last.Refresh(pc, nil)
+	assert.True(t, errors.Is(err, kfrag.ErrInvalidKFrag))
+
+	// the sub gates of a gate are shares of its original polynomial
+	policyFrags, policyPC, _ := KfragsGenPolicy(privAlice, privBob.PublicKey, kfrag.And(kfrag.Gate(2, kfrag.Leaf(1), kfrag.Leaf(1)), kfrag.Leaf(1)))
+	_, err = kfrag.RefreshAll(nil, policyPC, policyFrags[2])
+	assert.True(t, errors.Is(err, kfrag.ErrInvalidKFrag))
+	_, err = kfrag.RefreshAll(nil, policyPC, append(policyFrags[0], policyFrags[1]...))
+	assert.NoError(t, err)
+}
+
+func TestPolicy(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	sharedKey, cap, _ := Encapsulate(privAlice.PublicKey)
+
+	// 2 of 3 legal proxies and 3 of 5 infra shares, the first infra proxy holding two
+	legal := kfrag.Gate(2, kfrag.Leaf(1), kfrag.Leaf(1), kfrag.Leaf(1))
+	infra := kfrag.Gate(3, kfrag.Leaf(2), kfrag.Leaf(1), kfrag.Leaf(1), kfrag.Leaf(1))
+	leaves, pc, err := KfragsGenPolicy(privAlice, privBob.PublicKey, kfrag.And(legal, infra))
+	if !assert.NoError(t, err) || !assert.Len(t, leaves, 7) {
+		return
+	}
+	cFrags := make([][]*cfrag.CFrag, len(leaves))
+	for i, kFrags := range leaves {
+		for _, kFrag := range kFrags {
+			assert.NoError(t, kFrag.Verify(privAlice.PublicKey, privBob.PublicKey, pc))
+			cFrag, err := ReEncapsulate(kFrag, cap, nil)
+			assert.NoError(t, err)
+			decoded := new(cfrag.CFrag)
+			assert.NoError(t, decoded.Unmarshal(cFrag.Marshal()))
+			assert.Equal(t, cFrag.Path, decoded.Path)
+			cFrags[i] = append(cFrags[i], decoded)
+		}
+	}
+	pick := func(leaves ...int) []*cfrag.CFrag {
+		var picked []*cfrag.CFrag
+		for _, i := range leaves {
+			picked = append(picked, cFrags[i]...)
+		}
+		return picked
+	}
+
+	for _, set := range [][]int{{0, 1, 3, 4}, {1, 2, 4, 5, 6}, {0, 1, 2, 3, 4, 5, 6}} {
+		key, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, pick(set...))
+		assert.NoError(t, err)
+		assert.Equal(t, sharedKey, key)
+	}
+	for _, set := range [][]int{{0, 3, 4, 5, 6}, {0, 2, 4, 5}, {3, 4}} {
+		_, err := DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, pick(set...))
+		assert.True(t, errors.Is(err, ErrThresholdNotMet))
+	}
+
+	// one share of the weighted proxy is not enough in its place
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, append(pick(0, 1, 4), cFrags[3][0]))
+	assert.True(t, errors.Is(err, ErrThresholdNotMet))
+
+	// the proxies cannot lower a gate's threshold, it is signed by Alice
+	lowered := *pc
+	lowered.Gates = append([]*kfrag.GateCommitment(nil), pc.Gates...)
+	infraGate := *pc.Gates[2]
+	infraGate.Threshold = 1
+	infraGate.Coefficients = infraGate.Coefficients[:1]
+	lowered.Gates[2] = &infraGate
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, &lowered, cap, pick(0, 1, 4))
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	// nor move a legal share into the infra gate
+	moved := *cFrags[2][0]
+	moved.Path = []kfrag.PathStep{{Position: 1}}
+	_, err = DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, append(pick(0, 1, 3), &moved))
+	assert.True(t, errors.Is(err, &ErrInvalidCFrag{}))
+}
+
+func TestForward(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	privCarol, _ := keys.GenerateKey()
+	privDave, _ := keys.GenerateKey()
+	sharedKey, cap, _ := Encapsulate(privAlice.PublicKey)
+
+	hc, err := NewHopCapsule(cap, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	reEncapsulate := func(hc *HopCapsule, kFrags []*kfrag.KFrag) []*cfrag.CFrag {
+		var cFrags []*cfrag.CFrag
+		for _, kFrag := range kFrags {
+			cFrag, err := ReEncapsulateHop(kFrag, hc, nil)
+			assert.NoError(t, err)
+			cFrags = append(cFrags, cFrag)
+		}
+		return cFrags
+	}
+
+	// Alice to Bob, Bob to Carol, Carol to Dave, each without the delegator before
+	from, privs := privAlice, []*keys.PrivateKey{privBob, privCarol}
+	for _, priv := range privs {
+		kFrags, pc, _ := KfragsGen(from, priv.PublicKey, 3, 2)
+		cFrags := reEncapsulate(hc, kFrags[1:])
+		key, err := DecapsulateHopFrags(priv, from.PublicKey, pc, hc, cFrags)
+		assert.NoError(t, err)
+		assert.Equal(t, sharedKey, key)
+
+		hc, err = Forward(priv, from.PublicKey, pc, hc, cFrags)
+		if !assert.NoError(t, err) {
+			return
+		}
+		from = priv
+	}
+	assert.NoError(t, hc.Verify())
+
+	// the hops travel with their cfrag proofs and still verify once decoded
+	encoded, err := hc.Hex()
+	if !assert.NoError(t, err) {
+		return
+	}
+	decoded := &HopCapsule{}
+	if !assert.NoError(t, decoded.FromHex(encoded)) {
+		return
+	}
+	assert.NoError(t, decoded.Verify())
+	reencoded, _ := decoded.Hex()
+	assert.Equal(t, encoded, reencoded)
+	byt, _ := hc.Marshal()
+	assert.True(t, errors.Is(decoded.Unmarshal(byt[:len(byt)-1]), ErrMalformedEncoding))
+	assert.True(t, errors.Is(decoded.Unmarshal(append(byt, 0)), ErrMalformedEncoding))
+	hc = decoded
+
+	kFrags, pc, _ := KfragsGen(privCarol, privDave.PublicKey, 2, 2)
+	cFrags := reEncapsulate(hc, kFrags)
+	key, err := DecapsulateHopFrags(privDave, privCarol.PublicKey, pc, hc, cFrags)
+	assert.NoError(t, err)
+	assert.Equal(t, sharedKey, key)
+
+	_, err = Forward(privDave, privCarol.PublicKey, pc, hc, cFrags)
+	assert.True(t, errors.Is(err, ErrHopLimit))
+
+	// a hop that does not follow from its cfrags breaks the chain
+	hc.Hops[0].E = hc.Hops[0].E.Add(hc.Hops[0].V)
+	assert.True(t, errors.Is(hc.Verify(), ErrInvalidCapsule))
+	_, err = ReEncapsulateHop(kFrags[0], hc, nil)
+	assert.Error(t, err)
+}
+
+func TestForwardChain(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	privCarol, _ := keys.GenerateKey()
+	_, cap, _ := Encapsulate(privAlice.PublicKey)
+	hc, _ := NewHopCapsule(cap, 2)
+
+	kFrags, pc, _ := KfragsGen(privAlice, privBob.PublicKey, 2, 2)
+	var cFrags []*cfrag.CFrag
+	for _, kFrag := range kFrags {
+		cFrag, _ := ReEncapsulateHop(kFrag, hc, nil)
+		cFrags = append(cFrags, cFrag)
+	}
+	forwarded, err := Forward(privBob, privAlice.PublicKey, pc, hc, cFrags)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// missing arguments are rejected before anything is dereferenced
+	_, err = Forward(nil, privAlice.PublicKey, pc, hc, cFrags)
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+	_, err = Forward(privBob, nil, pc, forwarded, cFrags)
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+	_, err = Forward(privBob, privAlice.PublicKey, nil, forwarded, cFrags)
+	assert.True(t, errors.Is(err, ErrInvalidKFrag))
+	_, err = Forward(privBob, privAlice.PublicKey, pc, nil, cFrags)
+	assert.True(t, errors.Is(err, ErrInvalidCapsule))
+	_, err = NewHopCapsule(cap, MaxHops+1)
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+
+	// the hop proves the capsule was moved to Bob's key, not to one Bob picked
+	forged := *forwarded.Hops[0]
+	forged.E, forged.V = forged.E.Add(forged.E), forged.V.Add(forged.V)
+	forwarded.Hops[0] = &forged
+	assert.True(t, errors.Is(forwarded.Verify(), ErrInvalidCapsule))
+
+	// the next hop must come from the previous delegatee
+	forwarded, _ = Forward(privBob, privAlice.PublicKey, pc, hc, cFrags)
+	kFrags, pc, _ = KfragsGen(privAlice, privCarol.PublicKey, 1, 1)
+	cFrag, err := ReEncapsulateHop(kFrags[0], forwarded, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = Forward(privCarol, privAlice.PublicKey, pc, forwarded, []*cfrag.CFrag{cFrag})
+	assert.True(t, errors.Is(err, ErrInvalidCapsule))
+
+	// cfrags of a policy tree cannot be forwarded
+	leaves, treePC, _ := KfragsGenPolicy(privAlice, privBob.PublicKey, kfrag.And(kfrag.Gate(1, kfrag.Leaf(1), kfrag.Leaf(1)), kfrag.Leaf(1)))
+	cFrag, _ = ReEncapsulateHop(leaves[2][0], hc, nil)
+	_, err = Forward(privBob, privAlice.PublicKey, treePC, hc, []*cfrag.CFrag{cFrag})
+	assert.True(t, errors.Is(err, ErrInvalidCapsule))
+}
+
+func TestEncapsulateMulti(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	privOwner, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	privEve, _ := keys.GenerateKey()
+
+	sharedKey, mc, err := EncapsulateMulti([]*keys.PublicKey{privAlice.PublicKey, privOwner.PublicKey})
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, priv := range []*keys.PrivateKey{privAlice, privOwner} {
+		key, err := DecapsulateMulti(priv, mc)
+		assert.NoError(t, err)
+		assert.Equal(t, sharedKey, key)
+	}
+	_, err = DecapsulateMulti(privEve, mc)
+	assert.True(t, errors.Is(err, ErrDecryptionFailed))
+
+	// each owner can delegate the shared capsule on its own
+	kFrags, pc, _ := KfragsGen(privOwner, privBob.PublicKey, 3, 2)
+	var cFrags []*cfrag.CFrag
+	for _, kFrag := range kFrags[:2] {
+		cFrag, err := ReEncapsulate(kFrag, mc.Capsule, nil)
+		assert.NoError(t, err)
+		cFrags = append(cFrags, cFrag)
+	}
+	capsuleKey, err := DecapsulateFrags(privBob, privOwner.PublicKey, pc, mc.Capsule, cFrags)
+	assert.NoError(t, err)
+	key, err := mc.SharedKey(capsuleKey)
+	assert.NoError(t, err)
+	assert.Equal(t, sharedKey, key)
+
+	_, _, err = EncapsulateMulti([]*keys.PublicKey{privAlice.PublicKey, privAlice.PublicKey})
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+
+	decoded := new(MultiCapsule)
+	if !assert.NoError(t, decoded.FromHex(mc.Hex())) {
+		return
+	}
+	assert.Equal(t, mc.Marshal(), decoded.Marshal())
+	key, err = DecapsulateMulti(privAlice, decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, sharedKey, key)
+	data := mc.Marshal()
+	for _, bad := range [][]byte{data[:len(data)-1], append(data, 0), data[:len(data)-2*sealedKeyLen]} {
+		assert.True(t, errors.Is(decoded.Unmarshal(bad), ErrMalformedEncoding))
+	}
+}
@@ -0,0 +1,148 @@
+package symcrypt
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// StreamReaderAt gives random access to the plaintext of a stream produced by
+// NewStreamEncrypter. Only the chunks covering the requested range are read,
+// decrypted and authenticated; the most recently used chunk is cached.
+// It implements io.ReaderAt and io.ReadSeeker.
+type StreamReaderAt struct {
+	r         io.ReaderAt
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int64
+	chunks    int64
+	size      int64
+	offset    int64
+
+	mu         sync.Mutex
+	buf        []byte
+	cached     []byte
+	cachedIdx  int64
+	cacheValid bool
+}
+
+// NewStreamReaderAt reads the stream header from r, which holds size bytes of
+// encrypted stream, and returns a reader over the decrypted content.
+func NewStreamReaderAt(r io.ReaderAt, size int64, secretKey []byte) (*StreamReaderAt, error) {
+	header := make([]byte, streamHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("%w: cannot read stream header: %v", util.ErrMalformedEncoding, err)
+	}
+	chunkSize, err := parseStreamHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newStreamAEAD(secretKey, header[5:])
+	if err != nil {
+		return nil, err
+	}
+
+	body := size - streamHeaderSize
+	chunkCt := int64(chunkSize + streamTagSize)
+	if body < streamTagSize {
+		return nil, fmt.Errorf("%w: stream truncated", ErrDecryptionFailed)
+	}
+	chunks := (body + chunkCt - 1) / chunkCt
+	if body-(chunks-1)*chunkCt < streamTagSize {
+		return nil, fmt.Errorf("%w: stream truncated", ErrDecryptionFailed)
+	}
+
+	return &StreamReaderAt{
+		r:         r,
+		aead:      aead,
+		header:    header,
+		chunkSize: int64(chunkSize),
+		chunks:    chunks,
+		size:      body - chunks*streamTagSize,
+		buf:       make([]byte, chunkCt),
+	}, nil
+}
+
+// Size returns the length of the decrypted content.
+func (s *StreamReaderAt) Size() int64 {
+	return s.size
+}
+
+func (s *StreamReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%w: negative offset", util.ErrInvalidArgument)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		if off >= s.size {
+			return n, io.EOF
+		}
+		plain, err := s.chunk(off / s.chunkSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], plain[off%s.chunkSize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+func (s *StreamReaderAt) Read(p []byte) (int, error) {
+	n, err := s.ReadAt(p, s.offset)
+	s.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (s *StreamReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, fmt.Errorf("%w: invalid whence %d", util.ErrInvalidArgument, whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%w: negative offset", util.ErrInvalidArgument)
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// chunk returns the decrypted chunk idx, the caller must hold s.mu.
+func (s *StreamReaderAt) chunk(idx int64) ([]byte, error) {
+	if s.cacheValid && s.cachedIdx == idx {
+		return s.cached, nil
+	}
+	s.cacheValid = false
+
+	chunkCt := s.chunkSize + streamTagSize
+	start := streamHeaderSize + idx*chunkCt
+	final := idx == s.chunks-1
+	ctLen := chunkCt
+	if final {
+		ctLen = s.size - idx*s.chunkSize + streamTagSize
+	}
+
+	ct := s.buf[:ctLen]
+	if _, err := s.r.ReadAt(ct, start); err != nil && !(err == io.EOF && final) {
+		return nil, fmt.Errorf("cannot read stream chunk %d: %v", idx, err)
+	}
+	plain, err := s.aead.Open(ct[:0], streamNonce(uint64(idx), final), ct, s.header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: stream chunk %d: %v", ErrDecryptionFailed, idx, err)
+	}
+	s.cached, s.cachedIdx, s.cacheValid = plain, idx, true
+	return plain, nil
+}
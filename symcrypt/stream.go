@@ -0,0 +1,270 @@
+package symcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/util"
+	"golang.org/x/crypto/hkdf"
+)
+
+// The stream format follows the STREAM construction: the plaintext is cut into
+// chunks of chunkSize bytes and every chunk is sealed with AES-GCM under a nonce made
+// of the chunk counter and a flag marking the final chunk, so chunks cannot be
+// reordered, dropped or truncated without failing authentication.
+//
+//	header: version(1) || chunkSize(4) || salt(16)
+//	body:   chunk_0 || ... || chunk_n, each chunk is ciphertext || tag(16)
+//
+// Only the last chunk may be shorter than chunkSize; an empty plaintext is encoded as
+// a single empty final chunk. The chunk key is derived from the shared key and the
+// random salt, so the same shared key can safely encrypt several streams.
+const (
+	StreamVersion    = 1
+	DefaultChunkSize = 64 * 1024
+	MaxChunkSize     = 16 * 1024 * 1024
+
+	streamSaltSize   = 16
+	streamHeaderSize = 1 + 4 + streamSaltSize
+	streamTagSize    = 16
+	streamNonceSize  = 12
+)
+
+var streamInfo = []byte("prencrypt stream chunk key")
+
+func newStreamAEAD(secretKey, salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secretKey, salt, streamInfo), key); err != nil {
+		return nil, fmt.Errorf("cannot derive stream key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create new aes block: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create aes gcm: %v", err)
+	}
+	return aead, nil
+}
+
+func streamNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, streamNonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func parseStreamHeader(header []byte) (int, error) {
+	if header[0] != StreamVersion {
+		return 0, fmt.Errorf("%w: unsupported stream version %d", util.ErrMalformedEncoding, header[0])
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[1:5]))
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return 0, fmt.Errorf("%w: invalid stream chunk size %d", util.ErrMalformedEncoding, chunkSize)
+	}
+	return chunkSize, nil
+}
+
+type streamWriter struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int
+	counter   uint64
+	buf       []byte
+	out       []byte
+	closed    bool
+	err       error
+}
+
+// NewStreamEncrypter returns a WriteCloser that encrypts everything written to it
+// into w using chunks of chunkSize bytes (DefaultChunkSize when chunkSize is 0).
+// Close must be called to seal the final chunk; it does not close w.
+func NewStreamEncrypter(w io.Writer, secretKey []byte, chunkSize int) (io.WriteCloser, error) {
+	return NewStreamEncrypterWithRand(nil, w, secretKey, chunkSize)
+}
+
+// NewStreamEncrypterWithRand is NewStreamEncrypter drawing the salt from random,
+// nil means crypto/rand.
+func NewStreamEncrypterWithRand(random io.Reader, w io.Writer, secretKey []byte, chunkSize int) (io.WriteCloser, error) {
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize < 0 || chunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("%w: invalid stream chunk size %d", util.ErrInvalidArgument, chunkSize)
+	}
+
+	header := make([]byte, streamHeaderSize)
+	header[0] = StreamVersion
+	binary.BigEndian.PutUint32(header[1:5], uint32(chunkSize))
+	if _, err := io.ReadFull(util.RandReader(random), header[5:]); err != nil {
+		return nil, fmt.Errorf("cannot read random bytes for salt: %v", err)
+	}
+
+	aead, err := newStreamAEAD(secretKey, header[5:])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &streamWriter{
+		w:         w,
+		aead:      aead,
+		header:    header,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize),
+		out:       make([]byte, 0, chunkSize+streamTagSize),
+	}, nil
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.closed {
+		return 0, fmt.Errorf("%w: write to closed stream", util.ErrInvalidArgument)
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, so that the
+		// final chunk is always sealed by Close
+		if len(s.buf) == s.chunkSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):s.chunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+	return s.seal(true)
+}
+
+// seal encrypts and writes the buffered chunk. The first error is kept and
+// returned by every later Write and Close, as a chunk that was not written
+// leaves a gap no later chunk can fill.
+func (s *streamWriter) seal(final bool) error {
+	if s.counter == ^uint64(0) {
+		s.err = fmt.Errorf("%w: stream chunk counter overflow", util.ErrInvalidArgument)
+		return s.err
+	}
+	s.out = s.aead.Seal(s.out[:0], streamNonce(s.counter, final), s.buf, s.header)
+	s.counter++
+	s.buf = s.buf[:0]
+	if _, err := s.w.Write(s.out); err != nil {
+		s.err = err
+	}
+	return s.err
+}
+
+type streamReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int
+	counter   uint64
+	buf       []byte
+	plain     []byte
+	carry     byte
+	hasCarry  bool
+	done      bool
+	err       error
+}
+
+// NewStreamDecrypter returns a Reader that decrypts and authenticates a stream
+// produced by NewStreamEncrypter one chunk at a time. A stream that was truncated,
+// reordered or tampered with results in an error no later than the affected chunk.
+func NewStreamDecrypter(r io.Reader, secretKey []byte) (io.Reader, error) {
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: cannot read stream header: %v", util.ErrMalformedEncoding, err)
+	}
+	chunkSize, err := parseStreamHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newStreamAEAD(secretKey, header[5:])
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{
+		r:         r,
+		aead:      aead,
+		header:    header,
+		chunkSize: chunkSize,
+		buf:       make([]byte, chunkSize+streamTagSize+1),
+	}, nil
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// next reads one chunk plus a single byte of look-ahead, which tells whether the
+// chunk is the final one.
+func (s *streamReader) next() error {
+	start := 0
+	if s.hasCarry {
+		s.buf[0] = s.carry
+		start = 1
+	}
+	n, err := io.ReadFull(s.r, s.buf[start:])
+	total := start + n
+
+	var chunk []byte
+	final := false
+	switch err {
+	case nil:
+		chunk = s.buf[:total-1]
+		s.carry, s.hasCarry = s.buf[total-1], true
+	case io.EOF, io.ErrUnexpectedEOF:
+		chunk = s.buf[:total]
+		s.hasCarry = false
+		final = true
+	default:
+		return err
+	}
+	if len(chunk) < streamTagSize {
+		return fmt.Errorf("%w: stream truncated", ErrDecryptionFailed)
+	}
+
+	plain, err := s.aead.Open(chunk[:0], streamNonce(s.counter, final), chunk, s.header)
+	if err != nil {
+		return fmt.Errorf("%w: stream chunk %d: %v", ErrDecryptionFailed, s.counter, err)
+	}
+	s.counter++
+	s.plain = plain
+	s.done = final
+	return nil
+}
@@ -0,0 +1,142 @@
+package symcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hongyuefan/prencrypt/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func encryptStream(t *testing.T, key, plainText []byte, chunkSize int) []byte {
+	var out bytes.Buffer
+	w, err := NewStreamEncrypter(&out, key, chunkSize)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = w.Write(plainText)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return out.Bytes()
+}
+
+func TestStream(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	for _, size := range []int{0, 1, 15, 16, 17, 64, 1000} {
+		plainText := make([]byte, size)
+		rand.Read(plainText)
+
+		cipherText := encryptStream(t, key, plainText, 16)
+		r, err := NewStreamDecrypter(bytes.NewReader(cipherText), key)
+		if !assert.NoError(t, err) {
+			return
+		}
+		decrypted, err := ioutil.ReadAll(r)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, plainText, decrypted)
+
+		// dropping whole chunks or bytes from the tail must be detected
+		for _, cut := range []int{1, streamTagSize, streamTagSize + 16} {
+			if cut > len(cipherText)-streamHeaderSize {
+				continue
+			}
+			r, err := NewStreamDecrypter(bytes.NewReader(cipherText[:len(cipherText)-cut]), key)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = ioutil.ReadAll(r)
+			assert.Error(t, err, "size %d cut %d", size, cut)
+		}
+	}
+}
+
+// failingWriter accepts the header and fails every later write.
+type failingWriter struct{ writes int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > 1 {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestStreamWriteErrorIsSticky(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	w, err := NewStreamEncrypter(&failingWriter{}, key, 16)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// the second chunk seals the first, which fails to write
+	_, err = w.Write(make([]byte, 17))
+	assert.EqualError(t, err, "disk full")
+	_, err = w.Write(make([]byte, 1))
+	assert.EqualError(t, err, "disk full")
+	assert.EqualError(t, w.Close(), "disk full")
+	assert.EqualError(t, w.Close(), "disk full")
+}
+
+func TestStreamReaderAt(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	plainText := make([]byte, 1000)
+	rand.Read(plainText)
+	cipherText := encryptStream(t, key, plainText, 64)
+
+	r, err := NewStreamReaderAt(bytes.NewReader(cipherText), int64(len(cipherText)), key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(len(plainText)), r.Size())
+
+	for _, rng := range [][2]int{{0, 10}, {60, 70}, {128, 256}, {990, 1000}, {0, 1000}} {
+		buf := make([]byte, rng[1]-rng[0])
+		n, err := r.ReadAt(buf, int64(rng[0]))
+		assert.NoError(t, err)
+		assert.Equal(t, len(buf), n)
+		assert.Equal(t, plainText[rng[0]:rng[1]], buf)
+	}
+
+	_, err = r.ReadAt(make([]byte, 1), -1)
+	assert.True(t, errors.Is(err, util.ErrInvalidArgument))
+	_, err = r.Seek(0, 3)
+	assert.True(t, errors.Is(err, util.ErrInvalidArgument))
+	_, err = NewStreamEncrypter(ioutil.Discard, key, MaxChunkSize+1)
+	assert.True(t, errors.Is(err, util.ErrInvalidArgument))
+
+	_, err = r.Seek(500, 0)
+	assert.NoError(t, err)
+	rest, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, plainText[500:], rest)
+
+	// flipping a bit in one chunk only breaks reads touching that chunk
+	cipherText[streamHeaderSize+3*(64+streamTagSize)] ^= 1
+	r, err = NewStreamReaderAt(bytes.NewReader(cipherText), int64(len(cipherText)), key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = r.ReadAt(make([]byte, 10), 0)
+	assert.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 10), 3*64)
+	assert.Error(t, err)
+
+	// a stream with its final chunk removed must not authenticate
+	truncated := cipherText[:streamHeaderSize+2*(64+streamTagSize)]
+	r, err = NewStreamReaderAt(bytes.NewReader(truncated), int64(len(truncated)), key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = r.ReadAt(make([]byte, 10), 64)
+	assert.Error(t, err)
+}
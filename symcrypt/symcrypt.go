@@ -4,11 +4,33 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/util"
 )
 
+// ErrDecryptionFailed is returned when a ciphertext does not authenticate under the
+// given key and additional data.
+var ErrDecryptionFailed = errors.New("decryption failed")
+
 func EncryptAes(secretKey, msg []byte) ([]byte, error) {
+	return EncryptAesWithAD(secretKey, msg, nil)
+}
+
+func DecryptAes(secretKey, msg []byte) ([]byte, error) {
+	return DecryptAesWithAD(secretKey, msg, nil)
+}
+
+// EncryptAesWithAD is EncryptAes with additional data bound into the GCM tag.
+// The same ad must be supplied to DecryptAesWithAD.
+func EncryptAesWithAD(secretKey, msg, ad []byte) ([]byte, error) {
+	return EncryptAesWithRand(nil, secretKey, msg, ad)
+}
+
+// EncryptAesWithRand is EncryptAesWithAD drawing the nonce from random, nil means crypto/rand.
+func EncryptAesWithRand(random io.Reader, secretKey, msg, ad []byte) ([]byte, error) {
 
 	var ct bytes.Buffer
 
@@ -19,7 +41,7 @@ func EncryptAes(secretKey, msg []byte) ([]byte, error) {
 	}
 
 	nonce := make([]byte, 16)
-	if _, err := rand.Read(nonce); err != nil {
+	if _, err := io.ReadFull(util.RandReader(random), nonce); err != nil {
 		return nil, fmt.Errorf("cannot read random bytes for nonce: %v", err)
 	}
 
@@ -29,7 +51,7 @@ func EncryptAes(secretKey, msg []byte) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot create aes gcm: %v", err)
 	}
-	ciphertext := aesgcm.Seal(nil, nonce, msg, nil)
+	ciphertext := aesgcm.Seal(nil, nonce, msg, ad)
 	tag := ciphertext[len(ciphertext)-aesgcm.NonceSize():]
 	ct.Write(tag)
 	ciphertext = ciphertext[:len(ciphertext)-len(tag)]
@@ -37,10 +59,10 @@ func EncryptAes(secretKey, msg []byte) ([]byte, error) {
 	return ct.Bytes(), nil
 }
 
-func DecryptAes(secretKey, msg []byte) ([]byte, error) {
-	// Message cannot be less than length of public key (65) + nonce (16) + tag (16)
-	if len(msg) <= (16 + 16) {
-		return nil, fmt.Errorf("invalid length of message")
+func DecryptAesWithAD(secretKey, msg, ad []byte) ([]byte, error) {
+	// Message cannot be less than nonce (16) + tag (16), an empty plaintext adds nothing
+	if len(msg) < (16 + 16) {
+		return nil, fmt.Errorf("%w: invalid length of message", util.ErrMalformedEncoding)
 	}
 
 	// AES decryption part
@@ -60,9 +82,9 @@ func DecryptAes(secretKey, msg []byte) ([]byte, error) {
 		return nil, fmt.Errorf("cannot create gcm cipher: %v", err)
 	}
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, ad)
 	if err != nil {
-		return nil, fmt.Errorf("cannot decrypt ciphertext: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
 	}
 
 	return plaintext, nil
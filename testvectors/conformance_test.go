@@ -0,0 +1,110 @@
+package testvectors
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/hongyuefan/prencrypt"
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.NoError(t, err)
+	return b
+}
+
+// TestRegenerate checks that vectors.json is exactly what Generate derives from the seeds.
+func TestRegenerate(t *testing.T) {
+	vectors, err := Load("vectors.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	generated, err := GenerateAll()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, generated, vectors, "vectors.json is stale, run go generate ./testvectors")
+}
+
+// TestConformance replays the published values, without the seed, against every step.
+func TestConformance(t *testing.T) {
+	vectors, err := Load("vectors.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			privAlice, err := keys.NewPrivateKeyFromHex(v.AlicePrivateKey)
+			if !assert.NoError(t, err) {
+				return
+			}
+			privBob, err := keys.NewPrivateKeyFromHex(v.BobPrivateKey)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, v.AlicePublicKey, privAlice.PublicKey.Hex(true))
+			assert.Equal(t, v.BobPublicKey, privBob.PublicKey.Hex(true))
+
+			cap := capsule.NewCapsule()
+			if !assert.NoError(t, cap.FromHex(v.Capsule)) {
+				return
+			}
+			sharedKey, err := prencrypt.DecapsulateOriginal(privAlice, cap)
+			assert.NoError(t, err)
+			assert.Equal(t, v.SharedKey, hex.EncodeToString(sharedKey))
+
+			pc := &kfrag.PolicyCommitment{}
+			if !assert.NoError(t, pc.FromHex(v.PolicyCommitment)) {
+				return
+			}
+			assert.NoError(t, pc.Verify(privAlice.PublicKey, privBob.PublicKey))
+
+			// the proof depends on the randomness used by ReEncapsulate, the
+			// re-encrypted points do not
+			assert.Len(t, v.KFrags, v.N)
+			var cFrags []*cfrag.CFrag
+			for i, kfHex := range v.KFrags {
+				kf := kfrag.NewKFrag()
+				if !assert.NoError(t, kf.FromHex(kfHex)) {
+					return
+				}
+				assert.NoError(t, kf.Verify(privAlice.PublicKey, privBob.PublicKey, pc))
+				cf, err := prencrypt.ReEncapsulate(kf, cap, decodeHex(t, v.Aux))
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				decoded := cfrag.NewCFrag()
+				if !assert.NoError(t, decoded.Unmarshal(decodeHex(t, v.CFrags[i]))) {
+					return
+				}
+				assert.Equal(t, v.CFrags[i], hex.EncodeToString(decoded.Marshal()))
+				assert.True(t, decoded.Verify(cap.E, cap.V))
+				assert.True(t, cf.E1.IsEqual(decoded.E1) && cf.V1.IsEqual(decoded.V1))
+				assert.Equal(t, v.Aux, hex.EncodeToString(decoded.Pi.Aux))
+				cFrags = append(cFrags, decoded)
+			}
+
+			// any T of the published cfrags open the capsule
+			for _, subset := range [][]*cfrag.CFrag{cFrags[:v.T], cFrags[v.N-v.T:]} {
+				key, err := prencrypt.DecapsulateFrags(privBob, privAlice.PublicKey, pc, cap, subset)
+				assert.NoError(t, err)
+				assert.Equal(t, v.DecapsulatedKey, hex.EncodeToString(key))
+			}
+			assert.Equal(t, v.SharedKey, v.DecapsulatedKey)
+
+			encCap := capsule.NewCapsule()
+			if !assert.NoError(t, encCap.FromHex(v.EncryptCapsule)) {
+				return
+			}
+			plainText, err := prencrypt.DecryptOriginal(privAlice, encCap, decodeHex(t, v.EncryptCiphertext), decodeHex(t, v.Metadata))
+			assert.NoError(t, err)
+			assert.Equal(t, v.Plaintext, hex.EncodeToString(plainText))
+		})
+	}
+}
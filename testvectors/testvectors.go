@@ -0,0 +1,192 @@
+// Package testvectors generates and loads the published test vectors in
+// vectors.json, and those of the umbral package in umbral.json. Every vector is derived from a seed through Reader, so another
+// implementation can reproduce it by consuming the same random bytes in the same
+// order.
+package testvectors
+
+//go:generate go run ../cmd/testvectors -o vectors.json -umbral umbral.json
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/hongyuefan/prencrypt"
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/keys"
+)
+
+// Vector records the inputs and outputs of every protocol step. Byte strings are
+// hex encoded, points are compressed.
+type Vector struct {
+	Name string `json:"name"`
+	Seed string `json:"seed"`
+	N    int    `json:"n"`
+	T    int    `json:"t"`
+	Aux  string `json:"aux"`
+
+	AlicePrivateKey string `json:"alice_private_key"`
+	AlicePublicKey  string `json:"alice_public_key"`
+	BobPrivateKey   string `json:"bob_private_key"`
+	BobPublicKey    string `json:"bob_public_key"`
+
+	// Encapsulate
+	Capsule   string `json:"capsule"`
+	SharedKey string `json:"shared_key"`
+
+	// Rkgen, ReEncapsulate and DecapsulateFrags with the first T cfrags
+	KFrags           []string `json:"kfrags"`
+	PolicyCommitment string   `json:"policy_commitment"`
+	CFrags           []string `json:"cfrags"`
+	DecapsulatedKey  string   `json:"decapsulated_key"`
+
+	// Encrypt
+	Plaintext         string `json:"plaintext"`
+	Metadata          string `json:"metadata"`
+	EncryptCapsule    string `json:"encrypt_capsule"`
+	EncryptCiphertext string `json:"encrypt_ciphertext"`
+}
+
+// Reader is the deterministic random source the vectors are derived from: the
+// output is SHA256(seed || counter) for counter = 0, 1, ... as 8 bytes big-endian.
+type Reader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func NewReader(seed []byte) *Reader {
+	return &Reader{seed: append([]byte(nil), seed...)}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			var ctr [8]byte
+			binary.BigEndian.PutUint64(ctr[:], r.counter)
+			r.counter++
+			block := sha256.Sum256(append(append([]byte(nil), r.seed...), ctr[:]...))
+			r.buf = block[:]
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Generate derives a vector from seed. Randomness is consumed in this order: Alice's
+// key, Bob's key, Encapsulate, Rkgen, ReEncapsulate for each kfrag, Encrypt.
+func Generate(name string, seed []byte, N, t int, aux, plainText, metadata []byte) (*Vector, error) {
+	random := NewReader(seed)
+
+	privAlice, err := keys.GenerateKeyWithRand(random)
+	if err != nil {
+		return nil, err
+	}
+	privBob, err := keys.GenerateKeyWithRand(random)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedKey, capsule, err := prencrypt.EncapsulateWithRand(random, privAlice.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kFrags, pc, err := prencrypt.KfragsGenWithRand(random, privAlice, privBob.PublicKey, N, t)
+	if err != nil {
+		return nil, err
+	}
+
+	var kFragsHex, cFragsHex []string
+	var cFrags []*cfrag.CFrag
+	for _, kFrag := range kFrags {
+		cFrag, err := prencrypt.ReEncapsulateWithRand(random, kFrag, capsule, aux)
+		if err != nil {
+			return nil, err
+		}
+		kFragsHex = append(kFragsHex, kFrag.Hex())
+		cFragsHex = append(cFragsHex, hex.EncodeToString(cFrag.Marshal()))
+		cFrags = append(cFrags, cFrag)
+	}
+
+	decapsulatedKey, err := prencrypt.DecapsulateFrags(privBob, privAlice.PublicKey, pc, capsule, cFrags[:t])
+	if err != nil {
+		return nil, err
+	}
+
+	cipherText, encCapsule, err := prencrypt.EncryptWithRand(random, privAlice.PublicKey, plainText, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vector{
+		Name:              name,
+		Seed:              hex.EncodeToString(seed),
+		N:                 N,
+		T:                 t,
+		Aux:               hex.EncodeToString(aux),
+		AlicePrivateKey:   privAlice.Hex(),
+		AlicePublicKey:    privAlice.PublicKey.Hex(true),
+		BobPrivateKey:     privBob.Hex(),
+		BobPublicKey:      privBob.PublicKey.Hex(true),
+		Capsule:           capsule.Hex(),
+		SharedKey:         hex.EncodeToString(sharedKey),
+		KFrags:            kFragsHex,
+		PolicyCommitment:  pc.Hex(),
+		CFrags:            cFragsHex,
+		DecapsulatedKey:   hex.EncodeToString(decapsulatedKey),
+		Plaintext:         hex.EncodeToString(plainText),
+		Metadata:          hex.EncodeToString(metadata),
+		EncryptCapsule:    encCapsule.Hex(),
+		EncryptCiphertext: hex.EncodeToString(cipherText),
+	}, nil
+}
+
+// GenerateAll returns the published set of vectors.
+func GenerateAll() ([]*Vector, error) {
+	params := []struct {
+		name                     string
+		N, t                     int
+		aux, plainText, metadata string
+	}{
+		{"1-of-1", 1, 1, "", "hello", ""},
+		{"2-of-3", 3, 2, "proxy aux", "attack at dawn", ""},
+		{"3-of-5", 5, 3, "", "attack at dawn", "label"},
+		{"5-of-5", 5, 5, "aux", "the quick brown fox jumps over the lazy dog", "metadata"},
+	}
+	var vectors []*Vector
+	for _, p := range params {
+		seed := sha256.Sum256([]byte("prencrypt test vector " + p.name))
+		v, err := Generate(p.name, seed[:], p.N, p.t, []byte(p.aux), []byte(p.plainText), []byte(p.metadata))
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, nil
+}
+
+func Marshal(vectors []*Vector) ([]byte, error) {
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func Load(path string) ([]*Vector, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vectors []*Vector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
@@ -0,0 +1,257 @@
+package testvectors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/umbral"
+)
+
+// UmbralVectors are the vectors of the umbral package in umbral.json, laid out
+// like vectors.json.
+type UmbralVectors struct {
+	// U is the second generator the protocol vectors use, umbral-pre's.
+	U            string                `json:"u"`
+	HashToCurve  []*HashToCurveVector  `json:"hash_to_curve"`
+	HashToScalar []*HashToScalarVector `json:"hash_to_scalar"`
+	KDF          []*KDFVector          `json:"kdf"`
+	Protocol     []*UmbralVector       `json:"protocol"`
+}
+
+// HashToCurveVector is umbral.HashToCurve of Msg under DST, Point compressed.
+type HashToCurveVector struct {
+	DST   string `json:"dst"`
+	Msg   string `json:"msg"`
+	Point string `json:"point"`
+}
+
+type HashToScalarVector struct {
+	DST    string   `json:"dst"`
+	Parts  []string `json:"parts"`
+	Scalar string   `json:"scalar"`
+}
+
+type KDFVector struct {
+	Seed   string `json:"seed"`
+	Salt   string `json:"salt"`
+	Info   string `json:"info"`
+	Output string `json:"output"`
+}
+
+// UmbralVector records the outputs of umbral.Encrypt, GenerateKFrags and ReEncrypt;
+// any T of the cfrags decrypt the ciphertext to the plaintext.
+type UmbralVector struct {
+	Name              string `json:"name"`
+	Seed              string `json:"seed"`
+	N                 int    `json:"n"`
+	T                 int    `json:"t"`
+	SignDelegatingKey bool   `json:"sign_delegating_key"`
+	SignReceivingKey  bool   `json:"sign_receiving_key"`
+
+	AlicePrivateKey  string `json:"alice_private_key"`
+	AlicePublicKey   string `json:"alice_public_key"`
+	BobPrivateKey    string `json:"bob_private_key"`
+	BobPublicKey     string `json:"bob_public_key"`
+	SignerPrivateKey string `json:"signer_private_key"`
+	SignerPublicKey  string `json:"signer_public_key"`
+
+	Plaintext  string `json:"plaintext"`
+	Capsule    string `json:"capsule"`
+	KeySeed    string `json:"key_seed"`
+	DEMKey     string `json:"dem_key"`
+	Ciphertext string `json:"ciphertext"`
+
+	// KFragMessages are the messages signed for the receiver
+	KFrags        []string `json:"kfrags"`
+	KFragMessages []string `json:"kfrag_messages"`
+	CFrags        []string `json:"cfrags"`
+}
+
+// UmbralParams returns the parameters the protocol vectors are generated with.
+func UmbralParams() (*umbral.Params, error) {
+	return umbral.DefaultParams()
+}
+
+// GenerateUmbral derives a vector from seed. Randomness is consumed in this order:
+// Alice's, Bob's and the signer's keys, Encrypt, GenerateKFrags, ReEncrypt for
+// each kfrag.
+func GenerateUmbral(name string, seed []byte, N, t int, signDelegatingKey, signReceivingKey bool, plainText []byte) (*UmbralVector, error) {
+	params, err := UmbralParams()
+	if err != nil {
+		return nil, err
+	}
+	random := NewReader(seed)
+
+	var privs [3]*keys.PrivateKey
+	for i := range privs {
+		if privs[i], err = keys.GenerateKeyWithRand(random); err != nil {
+			return nil, err
+		}
+	}
+	privAlice, privBob, signer := privs[0], privs[1], privs[2]
+
+	capsule, cipherText, err := umbral.Encrypt(random, privAlice.PublicKey, plainText)
+	if err != nil {
+		return nil, err
+	}
+	keySeed, err := capsule.OpenOriginal(privAlice)
+	if err != nil {
+		return nil, err
+	}
+	demKey, err := umbral.DEMKey(keySeed)
+	if err != nil {
+		return nil, err
+	}
+
+	kFrags, err := umbral.GenerateKFrags(random, params, privAlice, privBob.PublicKey, signer, N, t, signDelegatingKey, signReceivingKey)
+	if err != nil {
+		return nil, err
+	}
+	var kFragsHex, messagesHex, cFragsHex []string
+	for _, kFrag := range kFrags {
+		cFrag, err := umbral.ReEncrypt(random, params, capsule, kFrag)
+		if err != nil {
+			return nil, err
+		}
+		msg := umbral.KFragSignatureMessage(kFrag.ID[:], kFrag.Commitment, kFrag.Precursor, privAlice.PublicKey, privBob.PublicKey)
+		kFragsHex = append(kFragsHex, hex.EncodeToString(kFrag.Marshal()))
+		messagesHex = append(messagesHex, hex.EncodeToString(msg))
+		cFragsHex = append(cFragsHex, hex.EncodeToString(cFrag.Marshal()))
+	}
+
+	return &UmbralVector{
+		Name:              name,
+		Seed:              hex.EncodeToString(seed),
+		N:                 N,
+		T:                 t,
+		SignDelegatingKey: signDelegatingKey,
+		SignReceivingKey:  signReceivingKey,
+		AlicePrivateKey:   privAlice.Hex(),
+		AlicePublicKey:    privAlice.PublicKey.Hex(true),
+		BobPrivateKey:     privBob.Hex(),
+		BobPublicKey:      privBob.PublicKey.Hex(true),
+		SignerPrivateKey:  signer.Hex(),
+		SignerPublicKey:   signer.PublicKey.Hex(true),
+		Plaintext:         hex.EncodeToString(plainText),
+		Capsule:           hex.EncodeToString(capsule.Marshal()),
+		KeySeed:           hex.EncodeToString(keySeed.Marshal()),
+		DEMKey:            hex.EncodeToString(demKey),
+		Ciphertext:        hex.EncodeToString(cipherText),
+		KFrags:            kFragsHex,
+		KFragMessages:     messagesHex,
+		CFrags:            cFragsHex,
+	}, nil
+}
+
+// GenerateAllUmbral returns the published set of umbral vectors.
+func GenerateAllUmbral() (*UmbralVectors, error) {
+	umbralParams, err := UmbralParams()
+	if err != nil {
+		return nil, err
+	}
+	vectors := &UmbralVectors{U: hex.EncodeToString(umbralParams.U.Marshal())}
+
+	// the RFC 9380 inputs of appendix J.8.1 and umbral-pre's U
+	rfcDST := "QUUX-V01-CS02-with-secp256k1_XMD:SHA-256_SSWU_RO_"
+	for _, h := range []struct{ dst, msg string }{
+		{rfcDST, ""},
+		{rfcDST, "abc"},
+		{rfcDST, "abcdef0123456789"},
+		{"PARAMETERS", "POINT_U"},
+	} {
+		p, err := umbral.HashToCurve([]byte(h.msg), []byte(h.dst))
+		if err != nil {
+			return nil, err
+		}
+		vectors.HashToCurve = append(vectors.HashToCurve, &HashToCurveVector{
+			DST:   hex.EncodeToString([]byte(h.dst)),
+			Msg:   hex.EncodeToString([]byte(h.msg)),
+			Point: hex.EncodeToString(p.Marshal()),
+		})
+	}
+
+	hashes := []struct {
+		dst   string
+		parts []string
+	}{
+		{"CAPSULE_POINTS", nil},
+		{"POLYNOMIAL_ARG", []string{"", "kfrag id"}},
+		{"SHARED_SECRET", []string{"precursor", "receiving key", "dh point"}},
+		{"CFRAG_VERIFICATION", []string{"the quick brown fox jumps over the lazy dog"}},
+	}
+	for _, h := range hashes {
+		v := &HashToScalarVector{DST: hex.EncodeToString([]byte(h.dst)), Parts: []string{}}
+		var parts [][]byte
+		for _, part := range h.parts {
+			v.Parts = append(v.Parts, hex.EncodeToString([]byte(part)))
+			parts = append(parts, []byte(part))
+		}
+		v.Scalar = umbral.HashToScalar([]byte(h.dst), parts...).Hex()
+		vectors.HashToScalar = append(vectors.HashToScalar, v)
+	}
+
+	kdfs := []struct {
+		seed, salt, info string
+		n                int
+	}{
+		{"key seed", "", "", 32},
+		{"key seed", "salt", "info", 32},
+		{"key seed", "", "info", 64},
+	}
+	for _, k := range kdfs {
+		seed := sha256.Sum256([]byte(k.seed))
+		out, err := umbral.KDF(seed[:], []byte(k.salt), []byte(k.info), k.n)
+		if err != nil {
+			return nil, err
+		}
+		vectors.KDF = append(vectors.KDF, &KDFVector{
+			Seed:   hex.EncodeToString(seed[:]),
+			Salt:   hex.EncodeToString([]byte(k.salt)),
+			Info:   hex.EncodeToString([]byte(k.info)),
+			Output: hex.EncodeToString(out),
+		})
+	}
+
+	params := []struct {
+		name                                string
+		N, t                                int
+		signDelegatingKey, signReceivingKey bool
+		plainText                           string
+	}{
+		{"1-of-1", 1, 1, false, false, "hello"},
+		{"2-of-3", 3, 2, true, false, "attack at dawn"},
+		{"3-of-5", 5, 3, true, true, "the quick brown fox jumps over the lazy dog"},
+	}
+	for _, p := range params {
+		seed := sha256.Sum256([]byte("prencrypt umbral test vector " + p.name))
+		v, err := GenerateUmbral(p.name, seed[:], p.N, p.t, p.signDelegatingKey, p.signReceivingKey, []byte(p.plainText))
+		if err != nil {
+			return nil, err
+		}
+		vectors.Protocol = append(vectors.Protocol, v)
+	}
+	return vectors, nil
+}
+
+func MarshalUmbral(vectors *UmbralVectors) ([]byte, error) {
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func LoadUmbral(path string) (*UmbralVectors, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vectors := &UmbralVectors{}
+	if err := json.Unmarshal(data, vectors); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
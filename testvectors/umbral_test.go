@@ -0,0 +1,128 @@
+package testvectors
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/umbral"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUmbralRegenerate checks that umbral.json is exactly what GenerateAllUmbral derives.
+func TestUmbralRegenerate(t *testing.T) {
+	vectors, err := LoadUmbral("umbral.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	generated, err := GenerateAllUmbral()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, generated, vectors, "umbral.json is stale, run go generate ./testvectors")
+}
+
+// TestUmbralConformance replays the published values, without the seeds.
+func TestUmbralConformance(t *testing.T) {
+	vectors, err := LoadUmbral("umbral.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, v := range vectors.HashToCurve {
+		p, err := umbral.HashToCurve(decodeHex(t, v.Msg), decodeHex(t, v.DST))
+		assert.NoError(t, err)
+		assert.Equal(t, v.Point, hex.EncodeToString(p.Marshal()))
+	}
+	for _, v := range vectors.HashToScalar {
+		var parts [][]byte
+		for _, part := range v.Parts {
+			parts = append(parts, decodeHex(t, part))
+		}
+		assert.Equal(t, v.Scalar, umbral.HashToScalar(decodeHex(t, v.DST), parts...).Hex())
+	}
+	for _, v := range vectors.KDF {
+		out, err := umbral.KDF(decodeHex(t, v.Seed), decodeHex(t, v.Salt), decodeHex(t, v.Info), len(v.Output)/2)
+		assert.NoError(t, err)
+		assert.Equal(t, v.Output, hex.EncodeToString(out))
+	}
+
+	u := point.NewPoint()
+	if !assert.NoError(t, u.Unmarshal(decodeHex(t, vectors.U))) {
+		return
+	}
+	params, err := umbral.DefaultParams()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, params.U.IsEqual(u), "u is not umbral-pre's U")
+	for _, v := range vectors.Protocol {
+		t.Run(v.Name, func(t *testing.T) {
+			var privs []*keys.PrivateKey
+			for _, k := range [][2]string{
+				{v.AlicePrivateKey, v.AlicePublicKey},
+				{v.BobPrivateKey, v.BobPublicKey},
+				{v.SignerPrivateKey, v.SignerPublicKey},
+			} {
+				priv, err := keys.NewPrivateKeyFromHex(k[0])
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, k[1], priv.PublicKey.Hex(true))
+				privs = append(privs, priv)
+			}
+			privAlice, privBob, signer := privs[0], privs[1], privs[2]
+
+			capsule := &umbral.Capsule{}
+			if !assert.NoError(t, capsule.Unmarshal(decodeHex(t, v.Capsule))) {
+				return
+			}
+			keySeed, err := capsule.OpenOriginal(privAlice)
+			assert.NoError(t, err)
+			assert.Equal(t, v.KeySeed, hex.EncodeToString(keySeed.Marshal()))
+			demKey, err := umbral.DEMKey(keySeed)
+			assert.NoError(t, err)
+			assert.Equal(t, v.DEMKey, hex.EncodeToString(demKey))
+			plainText, err := umbral.DecryptOriginal(privAlice, capsule, decodeHex(t, v.Ciphertext))
+			assert.NoError(t, err)
+			assert.Equal(t, v.Plaintext, hex.EncodeToString(plainText))
+
+			var alicePub, bobPub *keys.PublicKey
+			if v.SignDelegatingKey {
+				alicePub = privAlice.PublicKey
+			}
+			if v.SignReceivingKey {
+				bobPub = privBob.PublicKey
+			}
+			assert.Len(t, v.KFrags, v.N)
+			var cFrags []*umbral.CFrag
+			for i := range v.KFrags {
+				kf := &umbral.KFrag{}
+				if !assert.NoError(t, kf.Unmarshal(decodeHex(t, v.KFrags[i]))) {
+					return
+				}
+				assert.Equal(t, v.KFrags[i], hex.EncodeToString(kf.Marshal()))
+				assert.NoError(t, kf.Verify(params, signer.PublicKey, alicePub, bobPub))
+				msg := umbral.KFragSignatureMessage(kf.ID[:], kf.Commitment, kf.Precursor, privAlice.PublicKey, privBob.PublicKey)
+				assert.Equal(t, v.KFragMessages[i], hex.EncodeToString(msg))
+				assert.NoError(t, umbral.Verify(signer.PublicKey, msg, kf.SignatureForReceiver))
+
+				cf := &umbral.CFrag{}
+				if !assert.NoError(t, cf.Unmarshal(decodeHex(t, v.CFrags[i]))) {
+					return
+				}
+				assert.Equal(t, v.CFrags[i], hex.EncodeToString(cf.Marshal()))
+				assert.NoError(t, cf.Verify(params, capsule, signer.PublicKey, privAlice.PublicKey, privBob.PublicKey))
+				cFrags = append(cFrags, cf)
+			}
+
+			// any T of the published cfrags open the capsule
+			for _, subset := range [][]*umbral.CFrag{cFrags[:v.T], cFrags[v.N-v.T:]} {
+				plainText, err := umbral.DecryptReencrypted(privBob, privAlice.PublicKey, capsule, subset, decodeHex(t, v.Ciphertext))
+				assert.NoError(t, err)
+				assert.Equal(t, v.Plaintext, hex.EncodeToString(plainText))
+			}
+		})
+	}
+}
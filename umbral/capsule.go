@@ -0,0 +1,149 @@
+package umbral
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// CapsuleLen is the length of the capsule encoding E || V || S.
+const CapsuleLen = 2*PointLen + curvebn.ScalarLen
+
+// Capsule is umbral-pre's capsule, E = r*G, V = u*G and S = u + r*h with
+// h = H(E, V). The key seed is (r+u)*A.
+type Capsule struct {
+	E *point.Point
+	V *point.Point
+	S *curvebn.Scalar
+}
+
+// Encapsulate returns a capsule for alicePub and the key seed it hides. The
+// randomness, r then u, is read from random, nil means crypto/rand.
+func Encapsulate(random io.Reader, alicePub *keys.PublicKey) (*Capsule, *point.Point, error) {
+	if err := alicePub.Validate(); err != nil {
+		return nil, nil, err
+	}
+	r, err := curvebn.RandomScalarWithRand(random)
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := curvebn.RandomScalarWithRand(random)
+	if err != nil {
+		return nil, nil, err
+	}
+	E := point.BaseMulBytes(r.Bytes())
+	V := point.BaseMulBytes(u.Bytes())
+	S := u.Add(r.Mul(capsuleHash(E, V)))
+	keySeed := alicePub.Point.MulBytes(r.Add(u).Bytes())
+	return &Capsule{E: E, V: V, S: S}, keySeed, nil
+}
+
+// Verify checks S*G == V + h*E.
+func (c *Capsule) Verify() error {
+	if c == nil || c.S == nil {
+		return fmt.Errorf("%w: capsule is incomplete", capsule.ErrInvalidCapsule)
+	}
+	if err := point.ValidatePoints(c.E, c.V); err != nil {
+		return fmt.Errorf("%w: %v", capsule.ErrInvalidCapsule, err)
+	}
+	h := capsuleHash(c.E, c.V)
+	if !point.BaseMul(c.S.Int()).IsEqual(c.E.Mul(h.Int()).Add(c.V)) {
+		return fmt.Errorf("%w: capsule verification failed", capsule.ErrInvalidCapsule)
+	}
+	return nil
+}
+
+func (c *Capsule) Marshal() []byte {
+	return util.AppendByt(c.E.Marshal(), c.V.Marshal(), c.S.Bytes())
+}
+
+// Unmarshal decodes and verifies a capsule encoded by Marshal or umbral-pre.
+func (c *Capsule) Unmarshal(data []byte) error {
+	if len(data) != CapsuleLen {
+		return fmt.Errorf("%w: capsule data length error", util.ErrMalformedEncoding)
+	}
+	E, V := point.NewPoint(), point.NewPoint()
+	if err := decodePoints(data, E, V); err != nil {
+		return err
+	}
+	S, err := curvebn.ScalarFromBytes(data[2*PointLen:])
+	if err != nil {
+		return err
+	}
+	decoded := &Capsule{E: E, V: V, S: S}
+	if err := decoded.Verify(); err != nil {
+		return err
+	}
+	*c = *decoded
+	return nil
+}
+
+// OpenOriginal returns the key seed (E+V)*a.
+func (c *Capsule) OpenOriginal(privAlice *keys.PrivateKey) (*point.Point, error) {
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	return c.E.Add(c.V).MulBytes(privAlice.Bytes()), nil
+}
+
+// OpenReencrypted returns the key seed from cfrags that have been checked with
+// CFrag.Verify. They combine to E' = r*a/d*G and V' = u*a/d*G, and the capsule
+// check A*S/d == h*E' + V' fails unless there are enough of them from one kfrag
+// set for Bob.
+func (c *Capsule) OpenReencrypted(privBob *keys.PrivateKey, alicePub *keys.PublicKey, cfrags []*CFrag) (*point.Point, error) {
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	if err := alicePub.Validate(); err != nil {
+		return nil, err
+	}
+	if len(cfrags) == 0 {
+		return nil, invalidCFrag(nil, "no cfrags")
+	}
+	precursor := cfrags[0].Precursor
+	for _, cf := range cfrags {
+		if err := cf.validate(); err != nil {
+			return nil, err
+		}
+		if !cf.Precursor.IsEqual(precursor) {
+			return nil, invalidCFrag(cf, "cfrags come from different kfrag sets")
+		}
+	}
+
+	bobPub := privBob.PublicKey.Point
+	dh := precursor.MulBytes(privBob.Bytes())
+	xs := make([]*curvebn.Scalar, len(cfrags))
+	for i, cf := range cfrags {
+		xs[i] = polynomialArg(precursor, bobPub, dh, cf.KFragID[:])
+	}
+	lambdas, err := curvebn.LagrangeCoefficients(xs)
+	if err != nil {
+		return nil, invalidCFrag(nil, err.Error())
+	}
+	ePoints := make([]*point.Point, len(cfrags))
+	vPoints := make([]*point.Point, len(cfrags))
+	scalars := make([][]byte, len(cfrags))
+	for i, cf := range cfrags {
+		ePoints[i], vPoints[i], scalars[i] = cf.E1, cf.V1, lambdas[i].Bytes()
+	}
+	E, err := point.MultiMulBytes(ePoints, scalars)
+	if err != nil {
+		return nil, err
+	}
+	V, err := point.MultiMulBytes(vPoints, scalars)
+	if err != nil {
+		return nil, err
+	}
+
+	d := sharedSecret(precursor, bobPub, dh)
+	h := capsuleHash(c.E, c.V)
+	if !alicePub.Point.MulBytes(c.S.Mul(d.Inverse()).Bytes()).IsEqual(E.Mul(h.Int()).Add(V)) {
+		return nil, invalidCFrag(nil, "cfrags do not open the capsule")
+	}
+	return E.Add(V).MulBytes(d.Bytes()), nil
+}
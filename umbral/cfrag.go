@@ -0,0 +1,157 @@
+package umbral
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// CFragLen is the length of the cfrag encoding
+//
+//	E1 || V1 || kfrag id || precursor ||
+//	E2 || V2 || kfrag commitment || kfrag pok || signature || kfrag signature
+//
+// where the second line is the proof.
+const CFragLen = 7*PointLen + KFragIDLen + curvebn.ScalarLen + SignatureLen
+
+// CFrag is umbral-pre's cfrag, E1 = rk*E and V1 = rk*V with a proof that the same
+// rk is committed to in KFragCommitment = rk*U, which the delegator signed for the
+// receiver in KFragSignature.
+type CFrag struct {
+	E1        *point.Point
+	V1        *point.Point
+	KFragID   [KFragIDLen]byte
+	Precursor *point.Point
+
+	E2              *point.Point
+	V2              *point.Point
+	KFragCommitment *point.Point
+	KFragPok        *point.Point
+	Signature       *curvebn.Scalar
+	KFragSignature  []byte
+}
+
+// ReEncrypt re-encrypts c with kf, which should have been checked with
+// KFrag.Verify. The proof nonce is read from random, nil means crypto/rand.
+func ReEncrypt(random io.Reader, params *Params, c *Capsule, kf *KFrag) (*CFrag, error) {
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	t, err := curvebn.RandomScalarWithRand(random)
+	if err != nil {
+		return nil, err
+	}
+	rk := kf.Key.Bytes()
+	cf := &CFrag{
+		E1:              c.E.MulBytes(rk),
+		V1:              c.V.MulBytes(rk),
+		KFragID:         kf.ID,
+		Precursor:       kf.Precursor,
+		E2:              c.E.MulBytes(t.Bytes()),
+		V2:              c.V.MulBytes(t.Bytes()),
+		KFragCommitment: kf.Commitment,
+		KFragPok:        params.U.MulBytes(t.Bytes()),
+		KFragSignature:  kf.SignatureForReceiver,
+	}
+	h := cf.challenge(params, c)
+	cf.Signature = t.Add(h.Mul(kf.Key))
+	return cf, nil
+}
+
+func (cf *CFrag) challenge(params *Params, c *Capsule) *curvebn.Scalar {
+	return cfragChallenge(c.E, cf.E1, cf.E2, c.V, cf.V1, cf.V2, params.U, cf.KFragCommitment, cf.KFragPok)
+}
+
+// validate checks that all fields of cf are set and its points are valid.
+func (cf *CFrag) validate() error {
+	if cf == nil || cf.Signature == nil || len(cf.KFragSignature) != SignatureLen {
+		return invalidCFrag(nil, "cfrag is incomplete")
+	}
+	if err := point.ValidatePoints(cf.E1, cf.V1, cf.Precursor, cf.E2, cf.V2, cf.KFragCommitment, cf.KFragPok); err != nil {
+		return invalidCFrag(cf, err.Error())
+	}
+	return nil
+}
+
+// Verify checks the delegator's signature on the kfrag commitment and that E1,
+// V1 and the commitment share one exponent:
+//
+//	z*E == E2 + h*E1, z*V == V2 + h*V1, z*U == pok + h*commitment
+func (cf *CFrag) Verify(params *Params, c *Capsule, signerPub, alicePub, bobPub *keys.PublicKey) error {
+	if err := c.Verify(); err != nil {
+		return err
+	}
+	if err := cf.validate(); err != nil {
+		return err
+	}
+	if err := alicePub.Validate(); err != nil {
+		return err
+	}
+	if err := bobPub.Validate(); err != nil {
+		return err
+	}
+	msg := KFragSignatureMessage(cf.KFragID[:], cf.KFragCommitment, cf.Precursor, alicePub, bobPub)
+	if err := Verify(signerPub, msg, cf.KFragSignature); err != nil {
+		return invalidCFrag(cf, "kfrag signature: "+err.Error())
+	}
+
+	h := cf.challenge(params, c).Int()
+	z := cf.Signature.Int()
+	if !c.E.Mul(z).IsEqual(cf.E2.Add(cf.E1.Mul(h))) ||
+		!c.V.Mul(z).IsEqual(cf.V2.Add(cf.V1.Mul(h))) ||
+		!params.U.Mul(z).IsEqual(cf.KFragPok.Add(cf.KFragCommitment.Mul(h))) {
+		return invalidCFrag(cf, "proof verification failed")
+	}
+	return nil
+}
+
+func (cf *CFrag) Marshal() []byte {
+	return util.AppendByt(cf.E1.Marshal(), cf.V1.Marshal(), cf.KFragID[:], cf.Precursor.Marshal(),
+		cf.E2.Marshal(), cf.V2.Marshal(), cf.KFragCommitment.Marshal(), cf.KFragPok.Marshal(),
+		cf.Signature.Bytes(), cf.KFragSignature)
+}
+
+// Unmarshal decodes a cfrag encoded by Marshal or umbral-pre. It does not check
+// the proof, see Verify.
+func (cf *CFrag) Unmarshal(data []byte) error {
+	if len(data) != CFragLen {
+		return fmt.Errorf("%w: cfrag data length error", util.ErrMalformedEncoding)
+	}
+	decoded := &CFrag{
+		E1: point.NewPoint(), V1: point.NewPoint(), Precursor: point.NewPoint(),
+		E2: point.NewPoint(), V2: point.NewPoint(), KFragCommitment: point.NewPoint(), KFragPok: point.NewPoint(),
+	}
+	if err := decodePoints(data, decoded.E1, decoded.V1); err != nil {
+		return err
+	}
+	data = data[2*PointLen:]
+	copy(decoded.KFragID[:], data)
+	data = data[KFragIDLen:]
+	if err := decodePoints(data, decoded.Precursor, decoded.E2, decoded.V2, decoded.KFragCommitment, decoded.KFragPok); err != nil {
+		return err
+	}
+	data = data[5*PointLen:]
+	s, err := curvebn.ScalarFromBytes(data[:curvebn.ScalarLen])
+	if err != nil {
+		return err
+	}
+	decoded.Signature = s
+	decoded.KFragSignature = append([]byte(nil), data[curvebn.ScalarLen:]...)
+	*cf = *decoded
+	return nil
+}
+
+// invalidCFrag reports cf by its kfrag id, the root package's cfrag Id is a
+// scalar and has no counterpart here.
+func invalidCFrag(cf *CFrag, reason string) error {
+	if cf != nil {
+		reason = "kfrag id " + hex.EncodeToString(cf.KFragID[:]) + ": " + reason
+	}
+	return &cfrag.ErrInvalidCFrag{Reason: reason}
+}
@@ -0,0 +1,103 @@
+package umbral
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/symcrypt"
+	"github.com/hongyuefan/prencrypt/util"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// KDF derives n bytes from seed with HKDF-SHA256, as umbral-pre's kdf. salt and
+// info may be nil.
+func KDF(seed, salt, info []byte, n int) ([]byte, error) {
+	key := make([]byte, n)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, seed, salt, info), key); err != nil {
+		return nil, fmt.Errorf("cannot read secret from HKDF reader: %v", err)
+	}
+	return key, nil
+}
+
+// DEMKey is the XChaCha20-Poly1305 key umbral-pre's DEM derives from a key seed
+// point: KDF over the compressed point, without salt or info.
+func DEMKey(keySeed *point.Point) ([]byte, error) {
+	return KDF(keySeed.Marshal(), nil, nil, chacha20poly1305.KeySize)
+}
+
+// Seal encrypts plaintext under the key derived from keySeed, laid out as
+// nonce || ciphertext || tag with a 24 byte nonce read from random, nil means
+// crypto/rand.
+func Seal(random io.Reader, keySeed *point.Point, plaintext, ad []byte) ([]byte, error) {
+	key, err := DEMKey(keySeed)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(util.RandReader(random), nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// Open decrypts a ciphertext produced by Seal.
+func Open(keySeed *point.Point, ciphertext, ad []byte) ([]byte, error) {
+	key, err := DEMKey(keySeed)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("%w: ciphertext too short", symcrypt.ErrDecryptionFailed)
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, ciphertext[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", symcrypt.ErrDecryptionFailed, err)
+	}
+	return plaintext, nil
+}
+
+// Encrypt encapsulates a key seed for alicePub and seals plaintext under it with
+// the capsule encoding as associated data, as umbral-pre's encrypt.
+func Encrypt(random io.Reader, alicePub *keys.PublicKey, plaintext []byte) (*Capsule, []byte, error) {
+	c, keySeed, err := Encapsulate(random, alicePub)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := Seal(random, keySeed, plaintext, c.Marshal())
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, ciphertext, nil
+}
+
+// DecryptOriginal opens a ciphertext produced by Encrypt with Alice's key.
+func DecryptOriginal(privAlice *keys.PrivateKey, c *Capsule, ciphertext []byte) ([]byte, error) {
+	keySeed, err := c.OpenOriginal(privAlice)
+	if err != nil {
+		return nil, err
+	}
+	return Open(keySeed, ciphertext, c.Marshal())
+}
+
+// DecryptReencrypted opens a ciphertext produced by Encrypt with Bob's key and
+// verified cfrags, see Capsule.OpenReencrypted.
+func DecryptReencrypted(privBob *keys.PrivateKey, alicePub *keys.PublicKey, c *Capsule, cfrags []*CFrag, ciphertext []byte) ([]byte, error) {
+	keySeed, err := c.OpenReencrypted(privBob, alicePub, cfrags)
+	if err != nil {
+		return nil, err
+	}
+	return Open(keySeed, ciphertext, c.Marshal())
+}
@@ -0,0 +1,173 @@
+package umbral
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// The RFC 9380 suite secp256k1_XMD:SHA-256_SSWU_RO_: expand_message_xmd with
+// SHA-256, L = 48 bytes per field element and the simplified SWU map to the curve
+// E': y^2 = x^3 + A'x + B', 3-isogenous to secp256k1, with Z = -11. The inputs
+// are public, so the arithmetic is done on big.Int without constant-time care.
+var (
+	sswuA = hexInt("3f8731abdd661adca08a5558f0f5d272e953d363cb6f0e5d405447c01a444533")
+	sswuB = big.NewInt(1771)
+	sswuZ = new(big.Int).Sub(fieldP, big.NewInt(11))
+
+	// the 3-isogeny map from E' to secp256k1, RFC 9380 appendix E.1
+	isoXNum = hexInts(
+		"8e38e38e38e38e38e38e38e38e38e38e38e38e38e38e38e38e38e38daaaaa8c7",
+		"07d3d4c80bc321d5b9f315cea7fd44c5d595d2fc0bf63b92dfff1044f17c6581",
+		"534c328d23f234e6e2a413deca25caece4506144037c40314ecbd0b53d9dd262",
+		"8e38e38e38e38e38e38e38e38e38e38e38e38e38e38e38e38e38e38daaaaa88c",
+	)
+	isoXDen = hexInts(
+		"d35771193d94918a9ca34ccbb7b640dd86cd409542f8487d9fe6b745781eb49b",
+		"edadc6f64383dc1df7c4b2d51b54225406d36b641f5e41bbc52a56612a8c6d14",
+		"01",
+	)
+	isoYNum = hexInts(
+		"4bda12f684bda12f684bda12f684bda12f684bda12f684bda12f684b8e38e23c",
+		"c75e0c32d5cb7c0fa9d0a54b12a0a6d5647ab046d686da6fdffc90fc201d71a3",
+		"29a6194691f91a73715209ef6512e576722830a201be2018a765e85a9ecee931",
+		"2f684bda12f684bda12f684bda12f684bda12f684bda12f684bda12f38e38d84",
+	)
+	isoYDen = hexInts(
+		"fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffff93b",
+		"7a06534bb8bdb49fd5e9e6632722c2989467c1bfc8e8d978dfb425d2685c2573",
+		"6484aa716545ca2cf3a70c3fa8fe337e0a3d21162f0d6299a7bf8192bfd2a76f",
+		"01",
+	)
+)
+
+var fieldP = util.Curve.Params().P
+
+// hashToFieldLen is L, the bytes expanded per field element.
+const hashToFieldLen = 48
+
+func hexInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 16)
+	return n
+}
+
+func hexInts(s ...string) []*big.Int {
+	ns := make([]*big.Int, len(s))
+	for i := range s {
+		ns[i] = hexInt(s[i])
+	}
+	return ns
+}
+
+// HashToCurve is hash_to_curve of RFC 9380 under the suite
+// secp256k1_XMD:SHA-256_SSWU_RO_, which umbral-pre uses to derive U.
+func HashToCurve(msg, dst []byte) (*point.Point, error) {
+	uniform, err := ExpandMessageXMD(msg, dst, 2*hashToFieldLen)
+	if err != nil {
+		return nil, err
+	}
+	u0 := new(big.Int).SetBytes(uniform[:hashToFieldLen])
+	u1 := new(big.Int).SetBytes(uniform[hashToFieldLen:])
+	q0 := mapToCurve(u0.Mod(u0, fieldP))
+	q1 := mapToCurve(u1.Mod(u1, fieldP))
+	// secp256k1 has cofactor 1, clearing it is a no-op
+	return q0.Add(q1), nil
+}
+
+// ExpandMessageXMD is expand_message_xmd of RFC 9380 with SHA-256.
+func ExpandMessageXMD(msg, dst []byte, n int) ([]byte, error) {
+	ell := (n + sha256.Size - 1) / sha256.Size
+	if ell > 255 || n > 0xffff || len(dst) > 255 {
+		return nil, fmt.Errorf("%w: expand_message_xmd length out of range", util.ErrInvalidArgument)
+	}
+	dstPrime := append(append([]byte(nil), dst...), byte(len(dst)))
+
+	h := sha256.New()
+	h.Write(make([]byte, sha256.BlockSize))
+	h.Write(msg)
+	h.Write([]byte{byte(n >> 8), byte(n), 0})
+	h.Write(dstPrime)
+	b0 := h.Sum(nil)
+
+	out := make([]byte, 0, ell*sha256.Size)
+	bi := make([]byte, sha256.Size)
+	for i := 1; i <= ell; i++ {
+		for j := range bi {
+			bi[j] ^= b0[j]
+		}
+		h.Reset()
+		h.Write(bi)
+		h.Write([]byte{byte(i)})
+		h.Write(dstPrime)
+		bi = h.Sum(nil)
+		out = append(out, bi...)
+	}
+	return out[:n], nil
+}
+
+// mapToCurve is the simplified SWU map to E' followed by the 3-isogeny to
+// secp256k1.
+func mapToCurve(u *big.Int) *point.Point {
+	x, y := mapToIsogenous(u)
+	xNum, xDen := evalPoly(isoXNum, x), evalPoly(isoXDen, x)
+	yNum, yDen := evalPoly(isoYNum, x), evalPoly(isoYDen, x)
+	X := fieldMul(xNum, new(big.Int).ModInverse(xDen, fieldP))
+	Y := fieldMul(y, fieldMul(yNum, new(big.Int).ModInverse(yDen, fieldP)))
+	return &point.Point{Curve: util.Curve, X: X, Y: Y}
+}
+
+// mapToIsogenous is the simplified SWU map of RFC 9380 section 6.6.2 to E'.
+func mapToIsogenous(u *big.Int) (*big.Int, *big.Int) {
+	zu2 := fieldMul(sswuZ, fieldMul(u, u))
+	tv1 := new(big.Int).Add(fieldMul(zu2, zu2), zu2)
+	tv1.Mod(tv1, fieldP)
+
+	// x1 = (-B/A) * (1 + 1/tv1), or B/(Z*A) when tv1 = 0
+	var x1 *big.Int
+	if tv1.Sign() == 0 {
+		x1 = fieldMul(sswuB, new(big.Int).ModInverse(fieldMul(sswuZ, sswuA), fieldP))
+	} else {
+		x1 = new(big.Int).ModInverse(tv1, fieldP)
+		x1.Add(x1, big.NewInt(1))
+		negB := new(big.Int).Sub(fieldP, sswuB)
+		x1 = fieldMul(fieldMul(negB, new(big.Int).ModInverse(sswuA, fieldP)), x1)
+	}
+
+	x, gx := x1, isogenousRHS(x1)
+	y := new(big.Int).ModSqrt(gx, fieldP)
+	if y == nil {
+		x = fieldMul(zu2, x1)
+		y = new(big.Int).ModSqrt(isogenousRHS(x), fieldP)
+	}
+	if u.Bit(0) != y.Bit(0) {
+		y.Sub(fieldP, y)
+	}
+	return x, y
+}
+
+// isogenousRHS returns x^3 + A'x + B'.
+func isogenousRHS(x *big.Int) *big.Int {
+	gx := fieldMul(fieldMul(x, x), x)
+	gx.Add(gx, fieldMul(sswuA, x))
+	gx.Add(gx, sswuB)
+	return gx.Mod(gx, fieldP)
+}
+
+// evalPoly returns sum(k_i * x^i) by Horner's rule.
+func evalPoly(k []*big.Int, x *big.Int) *big.Int {
+	result := new(big.Int).Set(k[len(k)-1])
+	for i := len(k) - 2; i >= 0; i-- {
+		result = fieldMul(result, x)
+		result.Add(result, k[i])
+		result.Mod(result, fieldP)
+	}
+	return result
+}
+
+func fieldMul(a, b *big.Int) *big.Int {
+	r := new(big.Int).Mul(a, b)
+	return r.Mod(r, fieldP)
+}
@@ -0,0 +1,207 @@
+package umbral
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// KFragIDLen is the length of the random kfrag id.
+const KFragIDLen = 32
+
+// KFragLen is the length of the kfrag encoding
+//
+//	id || key || precursor || commitment || signature for proxy ||
+//	signature for receiver || delegating key signed || receiving key signed
+//
+// with each flag one byte, 0 or 1.
+const KFragLen = KFragIDLen + curvebn.ScalarLen + 2*PointLen + 2*SignatureLen + 2
+
+// KFrag is umbral-pre's kfrag. Key is f(x) for x = H(precursor, B, dh, ID), and
+// the commitment Key*U is signed by the delegator twice: for the receiver over
+// both public keys, and for the proxy over the keys selected by the flags.
+type KFrag struct {
+	ID         [KFragIDLen]byte
+	Key        *curvebn.Scalar
+	Precursor  *point.Point
+	Commitment *point.Point
+
+	SignatureForProxy    []byte
+	SignatureForReceiver []byte
+	DelegatingKeySigned  bool
+	ReceivingKeySigned   bool
+}
+
+// GenerateKFrags splits the re-encryption key from privAlice to bobPub into n
+// kfrags, any t of which open a capsule, and signs them with signer.
+// Randomness is read from random, nil means crypto/rand, in this order: the
+// precursor, the t-1 coefficients, then for each kfrag its id and the nonces of
+// the signature for the receiver and for the proxy.
+func GenerateKFrags(random io.Reader, params *Params, privAlice *keys.PrivateKey, bobPub *keys.PublicKey, signer *keys.PrivateKey, n, t int, signDelegatingKey, signReceivingKey bool) ([]*KFrag, error) {
+	if t < 1 || t > n {
+		return nil, fmt.Errorf("%w: threshold %d out of range for %d kfrags", kfrag.ErrInvalidKFrag, t, n)
+	}
+	if err := bobPub.Validate(); err != nil {
+		return nil, err
+	}
+
+	x, err := curvebn.RandomScalarWithRand(random)
+	if err != nil {
+		return nil, err
+	}
+	precursor := point.BaseMulBytes(x.Bytes())
+	dh := bobPub.Point.MulBytes(x.Bytes())
+	d := sharedSecret(precursor, bobPub.Point, dh)
+
+	coefficients := make([]*curvebn.Scalar, t)
+	coefficients[0] = privAlice.Scalar().Mul(d.Inverse())
+	for i := 1; i < t; i++ {
+		if coefficients[i], err = curvebn.RandomScalarWithRand(random); err != nil {
+			return nil, err
+		}
+	}
+
+	var delegatingForProxy, receivingForProxy *keys.PublicKey
+	if signDelegatingKey {
+		delegatingForProxy = privAlice.PublicKey
+	}
+	if signReceivingKey {
+		receivingForProxy = bobPub
+	}
+
+	kfrags := make([]*KFrag, n)
+	for i := range kfrags {
+		kf := &KFrag{
+			Precursor:           precursor,
+			DelegatingKeySigned: signDelegatingKey,
+			ReceivingKeySigned:  signReceivingKey,
+		}
+		if _, err := io.ReadFull(util.RandReader(random), kf.ID[:]); err != nil {
+			return nil, err
+		}
+		// Horner's rule from the highest coefficient
+		arg := polynomialArg(precursor, bobPub.Point, dh, kf.ID[:])
+		kf.Key = coefficients[t-1]
+		for j := t - 2; j >= 0; j-- {
+			kf.Key = kf.Key.Mul(arg).Add(coefficients[j])
+		}
+		kf.Commitment = params.U.MulBytes(kf.Key.Bytes())
+
+		msg := KFragSignatureMessage(kf.ID[:], kf.Commitment, precursor, privAlice.PublicKey, bobPub)
+		if kf.SignatureForReceiver, err = Sign(random, signer, msg); err != nil {
+			return nil, err
+		}
+		msg = KFragSignatureMessage(kf.ID[:], kf.Commitment, precursor, delegatingForProxy, receivingForProxy)
+		if kf.SignatureForProxy, err = Sign(random, signer, msg); err != nil {
+			return nil, err
+		}
+		kfrags[i] = kf
+	}
+	return kfrags, nil
+}
+
+// KFragSignatureMessage is the message the delegator signs for a kfrag:
+//
+//	id || commitment || precursor || flag || [delegating key] || flag || [receiving key]
+//
+// where a flag is the byte 1 followed by the compressed key, or the byte 0 alone
+// for a nil key.
+func KFragSignatureMessage(id []byte, commitment, precursor *point.Point, alicePub, bobPub *keys.PublicKey) []byte {
+	msg := util.AppendByt(id, commitment.Marshal(), precursor.Marshal())
+	for _, pub := range []*keys.PublicKey{alicePub, bobPub} {
+		if pub == nil {
+			msg = append(msg, 0)
+		} else {
+			msg = append(append(msg, 1), pub.Bytes(true)...)
+		}
+	}
+	return msg
+}
+
+// Verify checks the commitment and the signature for the proxy. alicePub and
+// bobPub may be nil when the kfrag does not sign them.
+func (kf *KFrag) Verify(params *Params, signerPub, alicePub, bobPub *keys.PublicKey) error {
+	if kf == nil || kf.Key == nil || len(kf.SignatureForProxy) != SignatureLen || len(kf.SignatureForReceiver) != SignatureLen {
+		return fmt.Errorf("%w: kfrag is incomplete", kfrag.ErrInvalidKFrag)
+	}
+	if err := point.ValidatePoints(kf.Precursor, kf.Commitment); err != nil {
+		return fmt.Errorf("%w: %v", kfrag.ErrInvalidKFrag, err)
+	}
+	if !params.U.MulBytes(kf.Key.Bytes()).IsEqual(kf.Commitment) {
+		return fmt.Errorf("%w: commitment does not match the key", kfrag.ErrInvalidKFrag)
+	}
+	if !kf.DelegatingKeySigned {
+		alicePub = nil
+	} else if alicePub == nil {
+		return fmt.Errorf("%w: kfrag signs the delegating key, which was not given", kfrag.ErrInvalidKFrag)
+	}
+	if !kf.ReceivingKeySigned {
+		bobPub = nil
+	} else if bobPub == nil {
+		return fmt.Errorf("%w: kfrag signs the receiving key, which was not given", kfrag.ErrInvalidKFrag)
+	}
+	msg := KFragSignatureMessage(kf.ID[:], kf.Commitment, kf.Precursor, alicePub, bobPub)
+	if err := Verify(signerPub, msg, kf.SignatureForProxy); err != nil {
+		return fmt.Errorf("%w: %v", kfrag.ErrInvalidKFrag, err)
+	}
+	return nil
+}
+
+func (kf *KFrag) Marshal() []byte {
+	return util.AppendByt(kf.ID[:], kf.Key.Bytes(), kf.Precursor.Marshal(), kf.Commitment.Marshal(),
+		kf.SignatureForProxy, kf.SignatureForReceiver, []byte{boolByte(kf.DelegatingKeySigned), boolByte(kf.ReceivingKeySigned)})
+}
+
+// Unmarshal decodes a kfrag encoded by Marshal or umbral-pre. It does not check
+// the signature, see Verify.
+func (kf *KFrag) Unmarshal(data []byte) error {
+	if len(data) != KFragLen {
+		return fmt.Errorf("%w: kfrag data length error", util.ErrMalformedEncoding)
+	}
+	decoded := &KFrag{Precursor: point.NewPoint(), Commitment: point.NewPoint()}
+	copy(decoded.ID[:], data)
+	data = data[KFragIDLen:]
+	key, err := curvebn.ScalarFromBytes(data[:curvebn.ScalarLen])
+	if err != nil {
+		return err
+	}
+	decoded.Key = key
+	data = data[curvebn.ScalarLen:]
+	if err := decodePoints(data, decoded.Precursor, decoded.Commitment); err != nil {
+		return err
+	}
+	data = data[2*PointLen:]
+	decoded.SignatureForProxy = append([]byte(nil), data[:SignatureLen]...)
+	decoded.SignatureForReceiver = append([]byte(nil), data[SignatureLen:2*SignatureLen]...)
+	data = data[2*SignatureLen:]
+	if decoded.DelegatingKeySigned, err = parseBool(data[0]); err != nil {
+		return err
+	}
+	if decoded.ReceivingKeySigned, err = parseBool(data[1]); err != nil {
+		return err
+	}
+	*kf = *decoded
+	return nil
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func parseBool(b byte) (bool, error) {
+	switch b {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: flag is neither 0 nor 1", util.ErrMalformedEncoding)
+}
@@ -0,0 +1,86 @@
+package umbral
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+)
+
+// SignatureLen is the length of an umbral-pre signature, r || s.
+const SignatureLen = 2 * curvebn.ScalarLen
+
+// Sign produces the ECDSA signature umbral-pre's Signer makes: over the SHA-256
+// digest of msg, encoded r || s with s in the lower half of the group order.
+// umbral-pre draws the nonce as in RFC 6979, here it is read from random, nil
+// means crypto/rand; either verifies the same way.
+func Sign(random io.Reader, priv *keys.PrivateKey, msg []byte) ([]byte, error) {
+	e := messageScalar(msg)
+	for {
+		k, err := curvebn.RandomScalarWithRand(random)
+		if err != nil {
+			return nil, err
+		}
+		r := curvebn.NewScalar(point.BaseMulBytes(k.Bytes()).X)
+		if r.IsZero() {
+			continue
+		}
+		s := k.Inverse().Mul(e.Add(r.Mul(priv.Scalar())))
+		if s.IsZero() {
+			continue
+		}
+		if isHigh(s) {
+			s = s.Neg()
+		}
+		return util.AppendByt(r.Bytes(), s.Bytes()), nil
+	}
+}
+
+// Verify checks a signature made by Sign or by umbral-pre. Like umbral-pre it
+// rejects s in the upper half of the group order.
+func Verify(pub *keys.PublicKey, msg, sig []byte) error {
+	if err := pub.Validate(); err != nil {
+		return err
+	}
+	if len(sig) != SignatureLen {
+		return fmt.Errorf("%w: signature length error", util.ErrMalformedEncoding)
+	}
+	r, err := curvebn.ScalarFromBytes(sig[:curvebn.ScalarLen])
+	if err != nil {
+		return err
+	}
+	s, err := curvebn.ScalarFromBytes(sig[curvebn.ScalarLen:])
+	if err != nil {
+		return err
+	}
+	if r.IsZero() || s.IsZero() || isHigh(s) {
+		return keys.ErrInvalidSignature
+	}
+
+	// R = e/s*G + r/s*pub, all public values
+	w := s.Inverse()
+	R := point.BaseMul(messageScalar(msg).Mul(w).Int()).Add(pub.Point.Mul(r.Mul(w).Int()))
+	if R.IsIdentity() || !curvebn.NewScalar(R.X).IsEqual(r) {
+		return keys.ErrInvalidSignature
+	}
+	return nil
+}
+
+// messageScalar is the SHA-256 digest of msg reduced modulo N, which for a 256
+// bit order is the ECDSA bits2int of the digest.
+func messageScalar(msg []byte) *curvebn.Scalar {
+	digest := sha256.Sum256(msg)
+	return curvebn.ReduceScalar(digest[:])
+}
+
+var halfOrder = new(big.Int).Rsh(util.Curve.Params().N, 1)
+
+// isHigh reports whether s > N/2; s is part of a signature and public.
+func isHigh(s *curvebn.Scalar) bool {
+	return s.Int().Cmp(halfOrder) > 0
+}
@@ -0,0 +1,113 @@
+// Package umbral implements the wire format of NuCypher's umbral-pre, which
+// pyUmbral also follows: its hash to scalar, KDF and DEM, the kfrag signature
+// message and the fixed-size encodings of capsules, kfrags and cfrags. It is a
+// separate protocol from the one in the root package and the two do not mix; see
+// docs/umbral-compat.md for what has been checked against umbral-pre.
+package umbral
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hongyuefan/prencrypt/curvebn"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/point"
+)
+
+// PointLen is the length of a compressed point.
+const PointLen = 33
+
+// The domain separation tags umbral-pre hashes to scalars under.
+var (
+	capsulePointsDST     = []byte("CAPSULE_POINTS")
+	polynomialArgDST     = []byte("POLYNOMIAL_ARG")
+	sharedSecretDST      = []byte("SHARED_SECRET")
+	cfragVerificationDST = []byte("CFRAG_VERIFICATION")
+)
+
+// Params holds the second generator U. umbral-pre derives U by hashing "POINT_U"
+// to the curve under the tag "PARAMETERS", see DefaultParams; other values of U
+// do not interoperate with it.
+type Params struct {
+	U *point.Point
+}
+
+var (
+	uMsg = []byte("POINT_U")
+	uDST = []byte("PARAMETERS")
+)
+
+func NewParams(u *point.Point) (*Params, error) {
+	if err := u.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: parameter U: %v", keys.ErrInvalidKey, err)
+	}
+	return &Params{U: u}, nil
+}
+
+// DefaultParams returns umbral-pre's parameters, U = HashToCurve("POINT_U",
+// "PARAMETERS").
+func DefaultParams() (*Params, error) {
+	u, err := HashToCurve(uMsg, uDST)
+	if err != nil {
+		return nil, err
+	}
+	return NewParams(u)
+}
+
+// HashToScalar is umbral-pre's ScalarDigest: the SHA-256 digest of
+//
+//	be32(len dst) || dst || part || ...
+//
+// mapped to (digest mod (N-1)) + 1. Unlike curvebn.HashToScalar the parts are
+// not length prefixed; every caller hashes fixed-length values.
+func HashToScalar(dst []byte, parts ...[]byte) *curvebn.Scalar {
+	h := sha256.New()
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(dst)))
+	h.Write(n[:])
+	h.Write(dst)
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return curvebn.ReduceScalarNonZero(h.Sum(nil))
+}
+
+func hashPoints(dst []byte, points []*point.Point, extra ...[]byte) *curvebn.Scalar {
+	parts := make([][]byte, 0, len(points)+len(extra))
+	for _, p := range points {
+		parts = append(parts, p.Marshal())
+	}
+	return HashToScalar(dst, append(parts, extra...)...)
+}
+
+// capsuleHash is h in the capsule check S*G == V + h*E.
+func capsuleHash(E, V *point.Point) *curvebn.Scalar {
+	return hashPoints(capsulePointsDST, []*point.Point{E, V})
+}
+
+// polynomialArg is the share index of the kfrag id, which only the delegator and
+// the receiver can compute as dh = x*B = b*X.
+func polynomialArg(precursor, receivingPub, dh *point.Point, id []byte) *curvebn.Scalar {
+	return hashPoints(polynomialArgDST, []*point.Point{precursor, receivingPub, dh}, id)
+}
+
+// sharedSecret is d, with f(0) = a/d.
+func sharedSecret(precursor, receivingPub, dh *point.Point) *curvebn.Scalar {
+	return hashPoints(sharedSecretDST, []*point.Point{precursor, receivingPub, dh})
+}
+
+// cfragChallenge is the Fiat-Shamir challenge of the cfrag proof.
+func cfragChallenge(points ...*point.Point) *curvebn.Scalar {
+	return hashPoints(cfragVerificationDST, points)
+}
+
+// decodePoints splits data into compressed points.
+func decodePoints(data []byte, points ...*point.Point) error {
+	for i, p := range points {
+		if err := p.Unmarshal(data[i*PointLen : (i+1)*PointLen]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package umbral
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/hongyuefan/prencrypt/capsule"
+	"github.com/hongyuefan/prencrypt/cfrag"
+	"github.com/hongyuefan/prencrypt/keys"
+	"github.com/hongyuefan/prencrypt/kfrag"
+	"github.com/hongyuefan/prencrypt/point"
+	"github.com/hongyuefan/prencrypt/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func testParams(t *testing.T) *Params {
+	params, err := DefaultParams()
+	assert.NoError(t, err)
+	return params
+}
+
+// TestHashToCurve checks the suite against the secp256k1_XMD:SHA-256_SSWU_RO_
+// vectors of RFC 9380 appendix J.8.1 and expand_message_xmd against K.1.
+func TestHashToCurve(t *testing.T) {
+	out, err := ExpandMessageXMD(nil, []byte("QUUX-V01-CS02-with-expander-SHA256-128"), 0x20)
+	assert.NoError(t, err)
+	assert.Equal(t, "68a985b87eb6b46952128911f2a4412bbc302a9d759667f87f7a21d803f07235", hex.EncodeToString(out))
+
+	dst := []byte("QUUX-V01-CS02-with-secp256k1_XMD:SHA-256_SSWU_RO_")
+	for _, v := range []struct{ msg, x, y string }{
+		{"", "c1cae290e291aee617ebaef1be6d73861479c48b841eaba9b7b5852ddfeb1346", "64fa678e07ae116126f08b022a94af6de15985c996c3a91b64c406a960e51067"},
+		{"abc", "3377e01eab42db296b512293120c6cee72b6ecf9f9205760bd9ff11fb3cb2c4b", "7f95890f33efebd1044d382a01b1bee0900fb6116f94688d487c6c7b9c8371f6"},
+	} {
+		p, err := HashToCurve([]byte(v.msg), dst)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, v.x, fmt.Sprintf("%064x", p.X))
+		assert.Equal(t, v.y, fmt.Sprintf("%064x", p.Y))
+		assert.NoError(t, p.Validate())
+	}
+
+	_, err = ExpandMessageXMD(nil, make([]byte, 256), 32)
+	assert.True(t, errors.Is(err, util.ErrInvalidArgument))
+	_, err = ExpandMessageXMD(nil, dst, 255*32+1)
+	assert.True(t, errors.Is(err, util.ErrInvalidArgument))
+
+	params := testParams(t)
+	assert.False(t, params.U.IsEqual(point.UPoint()))
+}
+
+func TestReEncrypt(t *testing.T) {
+	params := testParams(t)
+	privAlice, _ := keys.GenerateKey()
+	privBob, _ := keys.GenerateKey()
+	privEve, _ := keys.GenerateKey()
+	signer, _ := keys.GenerateKey()
+
+	c, ciphertext, err := Encrypt(nil, privAlice.PublicKey, []byte("attack at dawn"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	plaintext, err := DecryptOriginal(privAlice, c, ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("attack at dawn"), plaintext)
+
+	kfrags, err := GenerateKFrags(nil, params, privAlice, privBob.PublicKey, signer, 5, 3, true, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var cfrags []*CFrag
+	for _, kf := range kfrags {
+		assert.NoError(t, kf.Verify(params, signer.PublicKey, privAlice.PublicKey, nil))
+		decodedKF := &KFrag{}
+		assert.NoError(t, decodedKF.Unmarshal(kf.Marshal()))
+		assert.Len(t, kf.Marshal(), KFragLen)
+		assert.Equal(t, kf.Marshal(), decodedKF.Marshal())
+
+		cf, err := ReEncrypt(nil, params, c, decodedKF)
+		if !assert.NoError(t, err) {
+			return
+		}
+		decodedCF := &CFrag{}
+		assert.NoError(t, decodedCF.Unmarshal(cf.Marshal()))
+		assert.Len(t, cf.Marshal(), CFragLen)
+		assert.NoError(t, decodedCF.Verify(params, c, signer.PublicKey, privAlice.PublicKey, privBob.PublicKey))
+		cfrags = append(cfrags, decodedCF)
+	}
+
+	for _, subset := range [][]*CFrag{cfrags[:3], cfrags[2:], cfrags} {
+		plaintext, err = DecryptReencrypted(privBob, privAlice.PublicKey, c, subset, ciphertext)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("attack at dawn"), plaintext)
+	}
+
+	// too few cfrags, or the wrong receiving key, fail the capsule check
+	_, err = DecryptReencrypted(privBob, privAlice.PublicKey, c, cfrags[:2], ciphertext)
+	assert.True(t, errors.Is(err, &cfrag.ErrInvalidCFrag{}), "%v", err)
+	_, err = DecryptReencrypted(privEve, privAlice.PublicKey, c, cfrags[:3], ciphertext)
+	assert.True(t, errors.Is(err, &cfrag.ErrInvalidCFrag{}), "%v", err)
+
+	// the signature for the receiver covers both keys
+	err = cfrags[0].Verify(params, c, signer.PublicKey, privAlice.PublicKey, privEve.PublicKey)
+	assert.True(t, errors.Is(err, &cfrag.ErrInvalidCFrag{}), "%v", err)
+	tampered := *cfrags[0]
+	tampered.E1 = tampered.E1.Add(point.UPoint())
+	err = tampered.Verify(params, c, signer.PublicKey, privAlice.PublicKey, privBob.PublicKey)
+	assert.True(t, errors.Is(err, &cfrag.ErrInvalidCFrag{}), "%v", err)
+
+	// the flags select which keys the proxy must be given
+	err = kfrags[0].Verify(params, signer.PublicKey, nil, nil)
+	assert.True(t, errors.Is(err, kfrag.ErrInvalidKFrag), "%v", err)
+	err = kfrags[0].Verify(params, privAlice.PublicKey, privAlice.PublicKey, nil)
+	assert.True(t, errors.Is(err, kfrag.ErrInvalidKFrag), "%v", err)
+}
+
+func TestCapsuleEncoding(t *testing.T) {
+	privAlice, _ := keys.GenerateKey()
+	c, _, err := Encapsulate(nil, privAlice.PublicKey)
+	if !assert.NoError(t, err) {
+		return
+	}
+	data := c.Marshal()
+	assert.Len(t, data, CapsuleLen)
+	decoded := &Capsule{}
+	assert.NoError(t, decoded.Unmarshal(data))
+	assert.Equal(t, data, decoded.Marshal())
+
+	data[len(data)-1] ^= 1
+	err = decoded.Unmarshal(data)
+	assert.True(t, errors.Is(err, capsule.ErrInvalidCapsule), "%v", err)
+	err = decoded.Unmarshal(data[1:])
+	assert.True(t, errors.Is(err, util.ErrMalformedEncoding), "%v", err)
+}
+
+func TestSignature(t *testing.T) {
+	priv, _ := keys.GenerateKey()
+	other, _ := keys.GenerateKey()
+	sig, err := Sign(nil, priv, []byte("message"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, Verify(priv.PublicKey, []byte("message"), sig))
+	assert.Equal(t, keys.ErrInvalidSignature, Verify(priv.PublicKey, []byte("other message"), sig))
+	assert.Equal(t, keys.ErrInvalidSignature, Verify(other.PublicKey, []byte("message"), sig))
+
+	// (r, N-s) is also a valid ECDSA signature, but not the low-s form
+	highS := new(big.Int).Sub(util.Curve.Params().N, new(big.Int).SetBytes(sig[32:]))
+	neg := util.ZeroPad(highS.Bytes(), 32)
+	assert.Equal(t, keys.ErrInvalidSignature, Verify(priv.PublicKey, []byte("message"), append(sig[:32:32], neg...)))
+}
@@ -0,0 +1,13 @@
+package util
+
+import (
+	"errors"
+)
+
+// ErrMalformedEncoding is wrapped by every decoder when its input cannot be parsed,
+// so callers can tell a bad request from a cryptographic failure.
+var ErrMalformedEncoding = errors.New("malformed encoding")
+
+// ErrInvalidArgument is wrapped when a caller passes a value no input could make
+// valid, such as a point off the curve, a negative offset or an oversized field.
+var ErrInvalidArgument = errors.New("invalid argument")
@@ -0,0 +1,14 @@
+package util
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// RandReader returns r, or crypto/rand.Reader when r is nil.
+func RandReader(r io.Reader) io.Reader {
+	if r == nil {
+		return rand.Reader
+	}
+	return r
+}
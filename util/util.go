@@ -10,7 +10,6 @@ import (
 	"strings"
 
 	"github.com/fomichev/secp256k1"
-	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/hkdf"
 )
 
@@ -34,19 +33,11 @@ func AppendByt(byts ...[]byte) []byte {
 	return byt
 }
 
-func Hash_class(data []byte) ([]byte, error) {
-	hash, err := blake2b.New(32, nil)
-	if err != nil {
-		return nil, err
-	}
-	return hash.Sum(data)[:32], nil
-}
-
 func ZeroPad(b []byte, leigth int) []byte {
-	for i := 0; i < leigth-len(b); i++ {
-		b = append([]byte{0x00}, b...)
+	if len(b) >= leigth {
+		return b
 	}
-	return b
+	return append(make([]byte, leigth-len(b)), b...)
 }
 
 func Kdf(secret []byte) (key []byte, err error) {